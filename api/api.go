@@ -0,0 +1,226 @@
+// Package api is the wire format of the HTTP API: the requests, responses,
+// error codes and names the server and its clients share. It has no
+// dependencies, so that importing the client does not pull in the server.
+package api
+
+import "time"
+
+// APIPrefix is the path of the versioned API, under the base path
+const APIPrefix = "/api/v1"
+
+// UnixPrefix marks a listen entry as a unix socket path, ex: unix:/run/remote-move.sock
+const UnixPrefix = "unix:"
+
+// error codes of the API
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidName      = "invalid_name"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeNotAccessible    = "not_accessible"
+	CodePermission       = "permission_denied"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeCrossDevice      = "cross_device"
+	CodeNoSpace          = "no_space"
+	CodeOwnershipFailed  = "ownership_failed"
+	CodeInternal         = "internal"
+	CodeCSRF             = "csrf"
+	CodeUnsupportedMedia = "unsupported_media_type"
+	CodeTooLarge         = "too_large"
+	CodeRateLimited      = "rate_limited"
+	CodeUnauthorized     = "unauthorized"
+	CodeSecondFactor     = "second_factor_required"
+)
+
+type APIErrorDetail struct {
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Message   string `json:"message"`
+}
+
+type APIError struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Details []APIErrorDetail `json:"details,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// Source is a source directory, ID identifies it in /api/v1/sources/{source}
+type Source struct {
+	ID    string   `json:"id"`
+	Path  string   `json:"path"`
+	Items []string `json:"items"`
+}
+
+type DestinationSubdir struct {
+	Name   string         `json:"name"`
+	Path   string         `json:"path"` // root/subdir, as given to operations
+	Policy PolicyResponse `json:"policy"`
+}
+
+type Destination struct {
+	Root    string              `json:"root"`
+	Subdirs []DestinationSubdir `json:"subdirs"`
+}
+
+type OperationRequest struct {
+	Operation string   `json:"operation"` // move or copy
+	Src       string   `json:"src"`
+	Items     []string `json:"items"`
+	Dest      string   `json:"dest"`
+}
+
+type ItemResult struct {
+	Item   string    `json:"item"`
+	Status int       `json:"status"`
+	Error  *APIError `json:"error,omitempty"`
+}
+
+// Operation is a move or copy of several items, Status is the overall HTTP
+// status: 201 when every item succeeded, 207 when only some did, or the
+// status shared by the failed items.
+type Operation struct {
+	ID        string       `json:"id"`
+	User      string       `json:"user"`
+	Operation string       `json:"operation"`
+	Src       string       `json:"src"`
+	Dest      string       `json:"dest"`
+	Status    int          `json:"status"`
+	Started   time.Time    `json:"started"`
+	Finished  time.Time    `json:"finished"`
+	Results   []ItemResult `json:"results"`
+}
+
+// MoveOpertationResponse is a failed item, Code is the API error code
+type MoveOpertationResponse struct {
+	Operation string `json:"operation"`
+	Src       string `json:"source"`
+	Dest      string `json:"destination"`
+	Message   string `json:"message"`
+	Code      string `json:"code"`
+}
+
+// PolicyResponse is the effective ownership and permissions of a destination,
+// Match is the matching policy, empty when the global chownUsrGrp applies.
+type PolicyResponse struct {
+	Match      string `json:"match"`
+	Owner      string `json:"owner"`
+	FileMode   string `json:"fileMode"`
+	DirMode    string `json:"dirMode"`
+	SetgidDirs bool   `json:"setgidDirs"`
+}
+
+// DataResponse lists what the client may use, User is empty for anonymous
+// clients, Via is how it was identified and Operations are the allowed
+// operations.
+type DataResponse struct {
+	User                 string                    `json:"user"`
+	Via                  string                    `json:"via"`
+	Operations           []string                  `json:"operations"`
+	OpResponse           []MoveOpertationResponse  `json:"opResponse"`
+	ListingErrors        bool                      `json:"listingErrors"`
+	SrcDirAndItsContents map[string][]string       `json:"srcDirAndItsContents"`
+	Destinations         map[string][]string       `json:"destinations"`
+	DestinationPolicies  map[string]PolicyResponse `json:"destinationPolicies"`
+}
+
+type MoveRequest struct {
+	Src   string   `json:"src"`
+	Items []string `json:"items"`
+	Dest  string   `json:"dest"` // destination root name and subdir, ex: media/movies
+}
+
+// CSRFCookie holds the token the web ui sends back in the CSRFHeader
+const (
+	CSRFCookie = "remote_move_csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// SessionCookie holds the session of a logged in user
+const SessionCookie = "remote_move_session"
+
+// LoginRequest logs in, Code is the code of the authenticator app, or a
+// recovery code, of users having a second factor
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"`
+}
+
+// SessionResponse is who the client is and what it may do, User is empty
+// for anonymous clients
+type SessionResponse struct {
+	User       string   `json:"user"`
+	Roles      []string `json:"roles"`
+	Operations []string `json:"operations"`
+	Via        string   `json:"via"`
+}
+
+// TokenRequest creates an API token allowed the Operations from the Sources
+// to the Destinations, patterns as in the roles, until Expires
+type TokenRequest struct {
+	Name         string    `json:"name"`
+	Expires      time.Time `json:"expires"`
+	Operations   []string  `json:"operations"`
+	Sources      []string  `json:"sources"`
+	Destinations []string  `json:"destinations"`
+}
+
+// APIToken is an API token, Token is its secret, only answered when it is
+// created, and LastUsed is null until it is used
+type APIToken struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Token        string     `json:"token,omitempty"`
+	CreatedBy    string     `json:"createdBy"`
+	Created      time.Time  `json:"created"`
+	Expires      time.Time  `json:"expires"`
+	LastUsed     *time.Time `json:"lastUsed"`
+	Operations   []string   `json:"operations"`
+	Sources      []string   `json:"sources"`
+	Destinations []string   `json:"destinations"`
+}
+
+// TOTPStatus tells if the user has a second factor, Required when the client
+// can't log in without one
+type TOTPStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+	Required          bool `json:"required"`
+}
+
+// TOTPEnrollment is a new secret for the authenticator app, shown once
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPCode is a code of the authenticator app
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes log in once each instead of a code, shown once
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, pending while it
+// is being tried. ResponseStatus and Error are those of the last attempt,
+// ResponseStatus is 0 when the webhook did not answer.
+type WebhookDelivery struct {
+	ID             string    `json:"id"`
+	Webhook        string    `json:"webhook"`
+	Event          string    `json:"event"`
+	EventID        string    `json:"eventId"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseStatus int       `json:"responseStatus"`
+	Error          string    `json:"error,omitempty"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+}
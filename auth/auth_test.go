@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 section 11 and the usual PBKDF2-HMAC-SHA256 vectors
+	expect := map[int]string{
+		1:    "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	}
+	for iter, key := range expect {
+		if got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), iter, 32)); key != got {
+			t.Fatalf("pbkdf2 with %d iterations should be %s, got %s", iter, key, got)
+		}
+	}
+	got := hex.EncodeToString(pbkdf2([]byte("passwd"), []byte("salt"), 1, 64))
+	if "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783" != got {
+		t.Fatalf("pbkdf2 of several blocks is wrong, got %s", got)
+	}
+}
+
+func TestAuthority(t *testing.T) {
+	c := &conf.Configuration{
+		Roles: []conf.Role{
+			{Name: "media", Sources: []string{"/srv/downloads"}, Destinations: []string{"media"}, Operations: []string{"move"}},
+			{Name: "docs", Sources: []string{"/srv/*"}, Destinations: []string{"archive/docs"}, Operations: []string{"copy"}},
+		},
+		Users: []conf.User{
+			{Name: "alice", Password: hashPassword("secret", []byte("0123456789abcdef"), 10), Roles: []string{"media", "docs"}},
+		},
+	}
+	a, err := New(c)
+	if nil != err {
+		t.Fatalf("could not create authority %v", err)
+	}
+	if nil != a.Anonymous() {
+		t.Fatalf("with users and no anonymousRoles anonymous clients should log in")
+	}
+	if _, ok := a.Login("alice", "wrong"); ok {
+		t.Fatalf("a wrong password should not log in")
+	}
+	if _, ok := a.Login("bob", "secret"); ok {
+		t.Fatalf("an unknown user should not log in")
+	}
+	id, ok := a.Login("alice", "secret")
+	if !ok {
+		t.Fatalf("alice should log in")
+	}
+	p := a.Permissions(id)
+	if !p.CanRead("/srv/downloads") || !p.CanRead("/srv/tmp") || p.CanRead("/home/alice") {
+		t.Fatalf("sources should be the union of the roles")
+	}
+	if !p.CanWrite("media/movies") || !p.CanWrite("archive/docs") || p.CanWrite("archive/old") {
+		t.Fatalf("destinations should be the union of the roles")
+	}
+	if !p.Can("move") || !p.Can("copy") || p.Can("delete") {
+		t.Fatalf("operations should be the union of the roles %v", p.Operations())
+	}
+	if !p.CanGrantRead("/srv/*") || !p.CanGrantRead("/srv/tmp") || p.CanGrantRead("*") || p.CanGrantRead("/home/*") {
+		t.Fatalf("tokens should only be granted the sources of the roles")
+	}
+	if !p.CanGrantWrite("media") || !p.CanGrantWrite("media/movies") || p.CanGrantWrite("archive") || p.CanGrantWrite("archive/*") || p.CanGrantWrite("*") {
+		t.Fatalf("tokens should only be granted the destinations of the roles")
+	}
+
+	c.Users[0].Password = "plain"
+	if _, err = New(c); nil == err {
+		t.Fatalf("a password that is not a hash should be refused")
+	}
+
+	open, _ := New(&conf.Configuration{})
+	if All != open.Permissions(open.Anonymous()) {
+		t.Fatalf("without users anonymous clients should be allowed everything")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, the last 6 of the 8 digits
+	key := []byte("12345678901234567890")
+	expect := map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924", 2000000000: "279037"}
+	for ts, code := range expect {
+		if got := hotp(key, uint64(totpStep(time.Unix(ts, 0)))); code != got {
+			t.Fatalf("code at %d should be %s, got %s", ts, code, got)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "state", "totp.json")
+	s, err := OpenTOTPStore(file)
+	if nil != err {
+		t.Fatalf("a missing file should be empty %v", err)
+	}
+	now := time.Unix(1234567890, 0)
+	s.now = func() time.Time { return now }
+	secret := b32.EncodeToString(key)
+	if _, err = s.Enroll("alice", secret, "000000"); ErrInvalidCode != err {
+		t.Fatalf("enrolling with a wrong code should fail, got %v", err)
+	}
+	codes, err := s.Enroll("alice", secret, "005924")
+	if nil != err || recoveryCodes != len(codes) {
+		t.Fatalf("could not enroll %v", err)
+	}
+	if err = s.Verify("alice", "005924"); ErrInvalidCode != err {
+		t.Fatalf("a code should not be used twice, got %v", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	if err = s.Verify("alice", hotp(key, uint64(totpStep(now)))); nil != err {
+		t.Fatalf("the next code should be valid %v", err)
+	}
+
+	s, err = OpenTOTPStore(file)
+	if nil != err || !s.Enrolled("alice") || s.Enrolled("bob") {
+		t.Fatalf("the enrollment should be saved %v", err)
+	}
+	if err = s.Verify("alice", strings.ToUpper(codes[0])); nil != err {
+		t.Fatalf("a recovery code should log in %v", err)
+	}
+	if err = s.Verify("alice", codes[0]); ErrInvalidCode != err || recoveryCodes-1 != s.RecoveryCodesLeft("alice") {
+		t.Fatalf("a recovery code should be used once, got %v", err)
+	}
+	s.file = filepath.Join(file, "unwritable")
+	if err = s.Verify("alice", codes[1]); nil == err || recoveryCodes-1 != s.RecoveryCodesLeft("alice") {
+		t.Fatalf("a recovery code should be kept when using it could not be saved, got %v", err)
+	}
+	s.file = file
+	if err = s.Verify("alice", codes[1]); nil != err {
+		t.Fatalf("a recovery code kept after a failed save should log in %v", err)
+	}
+	if err = s.Disable("alice"); nil != err || s.Enrolled("alice") {
+		t.Fatalf("could not disable %v", err)
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	s, _ := OpenTokenStore(file)
+	now := time.Unix(1700000000, 0)
+	s.now = func() time.Time { return now }
+	raw, tok, err := s.Create(Token{Name: "backup", Expires: now.Add(time.Hour), Operations: []string{"copy"}, Sources: []string{"/srv/*"}, Destinations: []string{"archive"}})
+	if nil != err || !strings.HasPrefix(raw, TokenPrefix+tok.ID+"_") {
+		t.Fatalf("could not create a token %v", err)
+	}
+	data, _ := os.ReadFile(file)
+	if strings.Contains(string(data), raw[len(TokenPrefix)+len(tok.ID)+1:]) {
+		t.Fatalf("the secret should not be saved")
+	}
+
+	s, _ = OpenTokenStore(file)
+	s.now = func() time.Time { return now }
+	got, ok := s.Check(raw)
+	if !ok || !got.Permissions().CanRead("/srv/downloads") || got.Permissions().Can("move") || !got.LastUsed.Equal(now) {
+		t.Fatalf("the saved token should be valid and scoped %v", got)
+	}
+	if _, ok = s.Check(raw[:len(raw)-1]); ok {
+		t.Fatalf("a wrong secret should be refused")
+	}
+	now = now.Add(time.Hour)
+	if _, ok = s.Check(raw); ok {
+		t.Fatalf("an expired token should be refused")
+	}
+	if revoked, err := s.Revoke(tok.ID); !revoked || nil != err || 0 != len(s.List()) {
+		t.Fatalf("could not revoke %v", err)
+	}
+}
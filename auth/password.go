@@ -0,0 +1,96 @@
+// Package auth checks who is calling the server and what they may do.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// PasswordScheme prefixes the password hashes, pbkdf2-sha256$iter$salt$hash
+const PasswordScheme = "pbkdf2-sha256"
+
+// Iterations of PBKDF2 for new hashes
+const Iterations = 600000
+
+var b64 = base64.RawStdEncoding
+
+// pbkdf2 is PBKDF2 (RFC 8018) with HMAC-SHA256
+func pbkdf2(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	key := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	u := make([]byte, hashLen)
+	t := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for n := 1; n < iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range t {
+				t[x] ^= u[x]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func hashPassword(password string, salt []byte, iter int) string {
+	key := pbkdf2([]byte(password), salt, iter, sha256.Size)
+	return PasswordScheme + "$" + strconv.Itoa(iter) + "$" + b64.EncodeToString(salt) + "$" + b64.EncodeToString(key)
+}
+
+// HashPassword returns the hash to put in the password of a user
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); nil != err {
+		return "", err
+	}
+	return hashPassword(password, salt, Iterations), nil
+}
+
+type passwordHash struct {
+	iter int
+	salt []byte
+	key  []byte
+}
+
+func parseHash(hash string) (passwordHash, error) {
+	parts := strings.Split(hash, "$")
+	if 4 != len(parts) || PasswordScheme != parts[0] {
+		return passwordHash{}, errors.New("password should be a " + PasswordScheme + " hash, see remote-move passwd")
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if nil != err || iter < 1 {
+		return passwordHash{}, errors.New("invalid iterations in password hash")
+	}
+	salt, err := b64.DecodeString(parts[2])
+	if nil != err {
+		return passwordHash{}, errors.New("invalid salt in password hash")
+	}
+	key, err := b64.DecodeString(parts[3])
+	if nil != err || 0 == len(key) {
+		return passwordHash{}, errors.New("invalid key in password hash")
+	}
+	return passwordHash{iter: iter, salt: salt, key: key}, nil
+}
+
+func (p passwordHash) check(password string) bool {
+	key := pbkdf2([]byte(password), p.salt, p.iter, len(p.key))
+	return 1 == subtle.ConstantTimeCompare(key, p.key)
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"errors"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// How an identity was established
+const (
+	ViaAnonymous   = "anonymous"
+	ViaSession     = "session"
+	ViaToken       = "token"
+	ViaCertificate = "certificate"
+	ViaProxy       = "proxy"
+)
+
+// Identity is who is calling, Name is empty for anonymous clients. Scope,
+// for API tokens, replaces the permissions of the roles.
+type Identity struct {
+	Name  string
+	Roles []string
+	Via   string
+	Scope *Permissions
+}
+
+// Permissions are the union of the permissions of some roles
+type Permissions struct {
+	sources      []string
+	destinations []string
+	operations   []string
+}
+
+// All allows everything, for servers without users or roles
+var All = &Permissions{sources: []string{"*"}, destinations: []string{"*"}, operations: conf.Operations}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok || "*" == p {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead reports if the source directory src can be listed and taken from
+func (p *Permissions) CanRead(src string) bool {
+	return matchAny(p.sources, src)
+}
+
+// CanWrite reports if items can land in where, root/subdir. A pattern
+// without a / applies to every subdir of the root with that name.
+func (p *Permissions) CanWrite(where string) bool {
+	root, _, _ := strings.Cut(where, "/")
+	for _, pattern := range p.destinations {
+		name := where
+		if !strings.Contains(pattern, "/") {
+			name = root
+		}
+		if ok, _ := path.Match(pattern, name); ok || "*" == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports if pattern only matches what patterns do: it is one of
+// them, they have *, or it is a plain name that can allows
+func covers(patterns []string, pattern string, can func(string) bool) bool {
+	if slices.Contains(patterns, "*") || slices.Contains(patterns, pattern) {
+		return true
+	}
+	return !strings.ContainsAny(pattern, `*?[\`) && can(pattern)
+}
+
+// CanGrantRead reports if pattern, of the sources of a token, only allows
+// sources p allows
+func (p *Permissions) CanGrantRead(pattern string) bool {
+	return covers(p.sources, pattern, p.CanRead)
+}
+
+// CanGrantWrite reports if pattern, of the destinations of a token, only
+// allows destinations p allows
+func (p *Permissions) CanGrantWrite(pattern string) bool {
+	return covers(p.destinations, pattern, p.CanWrite)
+}
+
+// Can reports if the operation is allowed
+func (p *Permissions) Can(op string) bool {
+	return slices.Contains(p.operations, op)
+}
+
+// Operations lists the allowed operations
+func (p *Permissions) Operations() []string {
+	return append([]string(nil), p.operations...)
+}
+
+type user struct {
+	hash  passwordHash
+	roles []string
+}
+
+// Authority knows the users and roles of the configuration
+type Authority struct {
+	roles     map[string]conf.Role
+	users     map[string]user
+	anonymous []string
+	open      bool
+}
+
+// dummy is checked for unknown users, so that they take as long as known ones
+var dummy = passwordHash{iter: Iterations, salt: make([]byte, 16), key: make([]byte, 32)}
+
+// New returns the authority of the users and roles of c. Without users nor
+// anonymousRoles, anonymous clients are allowed everything, as they were
+// before users existed.
+func New(c *conf.Configuration) (*Authority, error) {
+	a := &Authority{
+		roles:     make(map[string]conf.Role, len(c.Roles)),
+		users:     make(map[string]user, len(c.Users)),
+		anonymous: c.AnonymousRoles,
+		open:      0 == len(c.Users) && 0 == len(c.AnonymousRoles),
+	}
+	for _, r := range c.Roles {
+		a.roles[r.Name] = r
+	}
+	for _, u := range c.Users {
+		hash, err := parseHash(u.Password)
+		if nil != err {
+			return nil, errors.New("user " + u.Name + ": " + err.Error())
+		}
+		a.users[u.Name] = user{hash: hash, roles: u.Roles}
+	}
+	return a, nil
+}
+
+// HasUsers reports if clients can log in
+func (a *Authority) HasUsers() bool {
+	return 0 != len(a.users)
+}
+
+// Anonymous is the identity of clients that did not authenticate, nil when
+// they must
+func (a *Authority) Anonymous() *Identity {
+	if !a.open && 0 == len(a.anonymous) {
+		return nil
+	}
+	return &Identity{Roles: a.anonymous, Via: ViaAnonymous}
+}
+
+// Login checks the password of a user
+func (a *Authority) Login(name, password string) (*Identity, bool) {
+	u, ok := a.users[name]
+	if !ok {
+		dummy.check(password)
+		return nil, false
+	}
+	if !u.hash.check(password) {
+		return nil, false
+	}
+	return &Identity{Name: name, Roles: u.roles, Via: ViaSession}, true
+}
+
+// Roles returns the roles of a user, false if it no longer exists
+func (a *Authority) Roles(name string) ([]string, bool) {
+	u, ok := a.users[name]
+	return u.roles, ok
+}
+
+// Permissions returns what an identity may do
+func (a *Authority) Permissions(id *Identity) *Permissions {
+	if nil != id.Scope {
+		return id.Scope
+	}
+	if a.open && ViaAnonymous == id.Via {
+		return All
+	}
+	p := &Permissions{}
+	for _, name := range id.Roles {
+		r := a.roles[name]
+		p.sources = append(p.sources, r.Sources...)
+		p.destinations = append(p.destinations, r.Destinations...)
+		for _, op := range r.Operations {
+			if !slices.Contains(p.operations, op) {
+				p.operations = append(p.operations, op)
+			}
+		}
+	}
+	return p
+}
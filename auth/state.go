@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// loadJSON reads the state file into v, a missing file leaves v as it is
+func loadJSON(file string, v any) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+	if err = json.Unmarshal(data, v); nil != err {
+		return errors.New(file + ": " + err.Error())
+	}
+	return nil
+}
+
+// saveJSON writes the state file anew, readable by the server only, and
+// renames it over the old one so that it is never half written
+func saveJSON(file string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if nil != err {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0700); nil != err {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = os.WriteFile(tmp, data, 0600); nil != err {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenPrefix starts every API token, rmt_<id>_<secret>
+const TokenPrefix = "rmt_"
+
+// lastUsedEvery is how often the last use of a token is saved at most
+const lastUsedEvery = time.Minute
+
+// Token is an API token allowed Operations from the Sources to the
+// Destinations, patterns as in the roles, until Expires. Only the hash of
+// its secret is kept.
+type Token struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Hash         string    `json:"hash"`
+	CreatedBy    string    `json:"createdBy"`
+	Created      time.Time `json:"created"`
+	Expires      time.Time `json:"expires"`
+	LastUsed     time.Time `json:"lastUsed"`
+	Operations   []string  `json:"operations"`
+	Sources      []string  `json:"sources"`
+	Destinations []string  `json:"destinations"`
+}
+
+// Permissions are what the token allows
+func (t *Token) Permissions() *Permissions {
+	return &Permissions{sources: t.Sources, destinations: t.Destinations, operations: t.Operations}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenStore keeps the API tokens in a json file only the server can read
+type TokenStore struct {
+	mu     sync.Mutex
+	file   string
+	tokens map[string]*Token
+	saved  map[string]time.Time
+	now    func() time.Time
+}
+
+// OpenTokenStore reads the tokens of file, a missing file has none
+func OpenTokenStore(file string) (*TokenStore, error) {
+	s := &TokenStore{file: file, tokens: make(map[string]*Token), saved: make(map[string]time.Time), now: time.Now}
+	if err := loadJSON(file, &s.tokens); nil != err {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TokenStore) save() error {
+	return saveJSON(s.file, s.tokens)
+}
+
+// Create saves t with a new ID and secret, and returns the token to hand out,
+// which can't be retrieved later
+func (s *TokenStore) Create(t Token) (string, Token, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); nil != err {
+		return "", Token{}, err
+	}
+	if _, err := rand.Read(secret); nil != err {
+		return "", Token{}, err
+	}
+	t.ID = hex.EncodeToString(id)
+	raw := TokenPrefix + t.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	t.Hash = hashSecret(raw)
+	t.LastUsed = time.Time{}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.Created = s.now()
+	s.tokens[t.ID] = &t
+	if err := s.save(); nil != err {
+		delete(s.tokens, t.ID)
+		return "", Token{}, err
+	}
+	return raw, t, nil
+}
+
+// List returns the tokens, oldest first
+func (s *TokenStore) List() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		ret = append(ret, *t)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Created.Before(ret[j].Created) })
+	return ret
+}
+
+// Revoke deletes the token id, false if there is none
+func (s *TokenStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.tokens, id)
+	if err := s.save(); nil != err {
+		s.tokens[id] = t
+		return false, err
+	}
+	return true, nil
+}
+
+// Check returns the token of raw, unless it is unknown or expired, and
+// records its use, saved once a minute at most
+func (s *TokenStore) Check(raw string) (*Token, bool) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(raw, TokenPrefix), "_")
+	if !ok || !strings.HasPrefix(raw, TokenPrefix) {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || 1 != subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hashSecret(raw))) {
+		return nil, false
+	}
+	now := s.now()
+	if !now.Before(t.Expires) {
+		return nil, false
+	}
+	t.LastUsed = now
+	if now.Sub(s.saved[id]) >= lastUsedEvery {
+		s.saved[id] = now
+		// the use is still recorded in memory when it can't be saved
+		s.save()
+	}
+	ret := *t
+	return &ret, true
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TOTP (RFC 6238) with the parameters every authenticator app defaults to:
+// HMAC-SHA1, 30 second steps and 6 digits. The codes of the previous and
+// next steps are accepted too, for clocks that drift.
+const (
+	totpPeriod    = 30
+	totpDigits    = 6
+	totpSkew      = 1
+	recoveryCodes = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrInvalidCode is a wrong TOTP or recovery code
+var ErrInvalidCode = errors.New("invalid code")
+
+// hotp is the HOTP (RFC 4226) code of counter
+func hotp(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	s := strconv.Itoa(int(code % 1000000))
+	return strings.Repeat("0", totpDigits-len(s)) + s
+}
+
+// totpStep is the step of t, the counter of its code
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// matchTOTP returns the step, around now, code is the code of, or -1
+func matchTOTP(key []byte, code string, now time.Time) int64 {
+	step := totpStep(now)
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		if 1 == subtle.ConstantTimeCompare([]byte(hotp(key, uint64(s))), []byte(code)) {
+			return s
+		}
+	}
+	return -1
+}
+
+// NewTOTPSecret returns a random base32 secret to enroll
+func NewTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); nil != err {
+		return "", err
+	}
+	return b32.EncodeToString(key), nil
+}
+
+// TOTPCode is the code of the base32 secret at t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := b32.DecodeString(secret)
+	if nil != err {
+		return "", err
+	}
+	return hotp(key, uint64(totpStep(t))), nil
+}
+
+// TOTPURI is the otpauth:// URI authenticator apps import the secret of user
+// from, as a QR code or pasted
+func TOTPURI(issuer, user, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", strconv.Itoa(totpDigits))
+	q.Set("period", strconv.Itoa(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+user) + "?" + q.Encode()
+}
+
+// normalizeCode drops the spaces and dashes people type in codes
+func normalizeCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes returns the codes to show and their hashes to keep
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+	b := make([]byte, 10)
+	for n := range codes {
+		if _, err := rand.Read(b); nil != err {
+			return nil, nil, err
+		}
+		s := strings.ToLower(b32.EncodeToString(b))
+		codes[n] = s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:]
+		hashes[n] = hashRecoveryCode(codes[n])
+	}
+	return codes, hashes, nil
+}
+
+// totpUser is the second factor of a user. Recovery holds the hashes of the
+// unused recovery codes, LastStep the step of the last code used, which
+// can't be used again.
+type totpUser struct {
+	Secret   string   `json:"secret"`
+	Recovery []string `json:"recovery"`
+	LastStep int64    `json:"lastStep"`
+}
+
+// TOTPStore keeps the TOTP secrets of the enrolled users in a json file only
+// the server can read
+type TOTPStore struct {
+	mu    sync.Mutex
+	file  string
+	users map[string]*totpUser
+	now   func() time.Time
+}
+
+// OpenTOTPStore reads the secrets of file, a missing file has none
+func OpenTOTPStore(file string) (*TOTPStore, error) {
+	s := &TOTPStore{file: file, users: make(map[string]*totpUser), now: time.Now}
+	if err := loadJSON(file, &s.users); nil != err {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TOTPStore) save() error {
+	return saveJSON(s.file, s.users)
+}
+
+// Enrolled reports if user has a second factor
+func (s *TOTPStore) Enrolled(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.users[user]
+	return ok
+}
+
+// RecoveryCodesLeft is the number of unused recovery codes of user
+func (s *TOTPStore) RecoveryCodesLeft(user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[user]; ok {
+		return len(u.Recovery)
+	}
+	return 0
+}
+
+// Verify checks the code of the authenticator of user, or one of its
+// recovery codes, which is then used up
+func (s *TOTPStore) Verify(user, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[user]
+	if !ok {
+		return ErrInvalidCode
+	}
+	code = normalizeCode(code)
+	if totpDigits == len(code) {
+		key, err := b32.DecodeString(u.Secret)
+		if nil != err {
+			return err
+		}
+		step := matchTOTP(key, code, s.now())
+		if step <= u.LastStep {
+			return ErrInvalidCode
+		}
+		prev := u.LastStep
+		u.LastStep = step
+		if err = s.save(); nil != err {
+			u.LastStep = prev
+			return err
+		}
+		return nil
+	}
+	hash := hashRecoveryCode(code)
+	for n, h := range u.Recovery {
+		if 1 == subtle.ConstantTimeCompare([]byte(h), []byte(hash)) {
+			prev := u.Recovery
+			u.Recovery = slices.Delete(slices.Clone(prev), n, n+1)
+			if err := s.save(); nil != err {
+				u.Recovery = prev
+				return err
+			}
+			return nil
+		}
+	}
+	return ErrInvalidCode
+}
+
+// Enroll gives user the secret, once code shows its authenticator has it,
+// and returns new recovery codes. An earlier secret is replaced.
+func (s *TOTPStore) Enroll(user, secret, code string) ([]string, error) {
+	key, err := b32.DecodeString(secret)
+	if nil != err {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	step := matchTOTP(key, normalizeCode(code), s.now())
+	if step < 0 {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if nil != err {
+		return nil, err
+	}
+	prev := s.users[user]
+	s.users[user] = &totpUser{Secret: secret, Recovery: hashes, LastStep: step}
+	if err = s.save(); nil != err {
+		s.users[user] = prev
+		if nil == prev {
+			delete(s.users, user)
+		}
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the second factor of user
+func (s *TOTPStore) Disable(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.users[user]
+	if !ok {
+		return nil
+	}
+	delete(s.users, user)
+	if err := s.save(); nil != err {
+		s.users[user] = prev
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+// Package client talks to the remote-move REST API, over tcp or a unix socket.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+// HTTPError is an error answer from the server, Code is the machine readable
+// code of the /api/v1 errors
+type HTTPError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return http.StatusText(e.StatusCode) + ": " + e.Message
+}
+
+// httpError reads the error answered in resp, a api.ErrorResponse or text
+func httpError(resp *http.Response) *HTTPError {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var er api.ErrorResponse
+	if nil == json.Unmarshal(msg, &er) && "" != er.Error.Code {
+		return &HTTPError{StatusCode: resp.StatusCode, Code: er.Error.Code, Message: er.Error.Message}
+	}
+	return &HTTPError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	csrf       string
+	httpClient *http.Client
+}
+
+// New returns a client for the server at baseURL, including the base path if
+// any (ex: https://home.lan/remote-move), or unix:/path/to.sock for a unix
+// socket. A non empty token is sent as a bearer token.
+func New(baseURL, token string) (*Client, error) {
+	jar, _ := cookiejar.New(nil)
+	csrf := make([]byte, 16)
+	rand.Read(csrf)
+	c := &Client{
+		token:      token,
+		csrf:       hex.EncodeToString(csrf),
+		httpClient: &http.Client{Timeout: 10 * time.Minute, Jar: jar},
+	}
+	if strings.HasPrefix(baseURL, api.UnixPrefix) {
+		path, basePath, _ := strings.Cut(strings.TrimPrefix(baseURL, api.UnixPrefix), ":")
+		c.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		c.baseURL = "http://unix" + strings.TrimSuffix(basePath, "/")
+		return c, nil
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		return nil, errors.New("invalid url " + baseURL + ", should be http(s)://host[:port][/basePath] or unix:/path[:/basePath]")
+	}
+	c.baseURL = strings.TrimSuffix(baseURL, "/")
+	return c, nil
+}
+
+// UseTLS sets the tls configuration of https servers, ex: the client
+// certificate and the CA of a server using a private CA
+func (c *Client) UseTLS(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	c.httpClient.Transport = transport
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if nil != body {
+		b, err := json.Marshal(body)
+		if nil != err {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if nil != err {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if nil != body {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if "" != c.token {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	// once logged in the session cookie is sent, which needs the CSRF
+	// token of the server's double submit check
+	req.AddCookie(&http.Cookie{Name: api.CSRFCookie, Value: c.csrf})
+	req.Header.Set(api.CSRFHeader, c.csrf)
+
+	return c.httpClient.Do(req)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return httpError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Data lists the source directories with their items and the destinations
+func (c *Client) Data(ctx context.Context) (*api.DataResponse, error) {
+	var data api.DataResponse
+	if err := c.do(ctx, http.MethodGet, "/data", nil, &data); nil != err {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// Move moves the items, the failed ones are in the OpResponse of the result
+func (c *Client) Move(ctx context.Context, req api.MoveRequest) (*api.DataResponse, error) {
+	var data api.DataResponse
+	if err := c.do(ctx, http.MethodPost, "/move", req, &data); nil != err {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// Copy copies the items, the failed ones are in the OpResponse of the result
+func (c *Client) Copy(ctx context.Context, req api.MoveRequest) (*api.DataResponse, error) {
+	var data api.DataResponse
+	if err := c.do(ctx, http.MethodPost, "/copy", req, &data); nil != err {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// ListSources lists the source directories with their items
+func (c *Client) ListSources(ctx context.Context) ([]api.Source, error) {
+	var srcs []api.Source
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/sources", nil, &srcs); nil != err {
+		return nil, err
+	}
+	return srcs, nil
+}
+
+// GetSource gets a source directory by its ID
+func (c *Client) GetSource(ctx context.Context, id string) (*api.Source, error) {
+	var src api.Source
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/sources/"+url.PathEscape(id), nil, &src); nil != err {
+		return nil, err
+	}
+	return &src, nil
+}
+
+// ListDestinations lists the destination roots with their subdirs
+func (c *Client) ListDestinations(ctx context.Context) ([]api.Destination, error) {
+	var dests []api.Destination
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/destinations", nil, &dests); nil != err {
+		return nil, err
+	}
+	return dests, nil
+}
+
+// GetDestination gets a destination root by its name
+func (c *Client) GetDestination(ctx context.Context, root string) (*api.Destination, error) {
+	var dest api.Destination
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/destinations/"+url.PathEscape(root), nil, &dest); nil != err {
+		return nil, err
+	}
+	return &dest, nil
+}
+
+// CreateOperation moves or copies the items. When some items failed the
+// operation is returned along with an *HTTPError of its status, 207 when
+// only some of them failed.
+func (c *Client) CreateOperation(ctx context.Context, req api.OperationRequest) (*api.Operation, error) {
+	resp, err := c.send(ctx, http.MethodPost, api.APIPrefix+"/operations", req)
+	if nil != err {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if http.StatusCreated == resp.StatusCode {
+		var op api.Operation
+		if err = json.NewDecoder(resp.Body).Decode(&op); nil != err {
+			return nil, err
+		}
+		return &op, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if nil != err {
+		return nil, err
+	}
+	var op api.Operation
+	if nil == json.Unmarshal(body, &op) && "" != op.ID {
+		return &op, &HTTPError{StatusCode: resp.StatusCode, Message: strconv.Itoa(failedItems(op)) + " of " + strconv.Itoa(len(op.Results)) + " items failed"}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil, httpError(resp)
+}
+
+func failedItems(op api.Operation) int {
+	failed := 0
+	for _, r := range op.Results {
+		if nil != r.Error {
+			failed++
+		}
+	}
+	return failed
+}
+
+// ListOperations lists the last operations, oldest first
+func (c *Client) ListOperations(ctx context.Context) ([]api.Operation, error) {
+	var ops []api.Operation
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/operations", nil, &ops); nil != err {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// GetOperation gets one of the last operations by its ID
+func (c *Client) GetOperation(ctx context.Context, id string) (*api.Operation, error) {
+	var op api.Operation
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/operations/"+url.PathEscape(id), nil, &op); nil != err {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// GetSession returns who the client is to the server and what it may do
+func (c *Client) GetSession(ctx context.Context) (*api.SessionResponse, error) {
+	var sess api.SessionResponse
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/session", nil, &sess); nil != err {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// Login logs in, the session is kept by the client for the next calls. code
+// is the code of the authenticator app, or a recovery code, of users having
+// a second factor, else empty.
+func (c *Client) Login(ctx context.Context, username, password, code string) (*api.SessionResponse, error) {
+	var sess api.SessionResponse
+	req := api.LoginRequest{Username: username, Password: password, Code: code}
+	if err := c.do(ctx, http.MethodPost, api.APIPrefix+"/session", req, &sess); nil != err {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// Logout ends the session
+func (c *Client) Logout(ctx context.Context) error {
+	resp, err := c.send(ctx, http.MethodDelete, api.APIPrefix+"/session", nil)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusNoContent != resp.StatusCode {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// GetTOTP tells if the logged in user has a second factor
+func (c *Client) GetTOTP(ctx context.Context) (*api.TOTPStatus, error) {
+	var status api.TOTPStatus
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/session/totp", nil, &status); nil != err {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// EnrollTOTP starts enrolling a second factor, the secret has to be added to
+// an authenticator app and confirmed with ConfirmTOTP
+func (c *Client) EnrollTOTP(ctx context.Context) (*api.TOTPEnrollment, error) {
+	var enrollment api.TOTPEnrollment
+	if err := c.do(ctx, http.MethodPost, api.APIPrefix+"/session/totp", struct{}{}, &enrollment); nil != err {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// ConfirmTOTP enrolls the second factor with a code of the authenticator app
+// and returns the recovery codes
+func (c *Client) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	var recovery api.RecoveryCodes
+	if err := c.do(ctx, http.MethodPut, api.APIPrefix+"/session/totp", api.TOTPCode{Code: code}, &recovery); nil != err {
+		return nil, err
+	}
+	return recovery.RecoveryCodes, nil
+}
+
+// DisableTOTP removes the second factor of the logged in user
+func (c *Client) DisableTOTP(ctx context.Context) error {
+	resp, err := c.send(ctx, http.MethodDelete, api.APIPrefix+"/session/totp", nil)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusNoContent != resp.StatusCode {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// ListTokens lists the API tokens, without their secrets
+func (c *Client) ListTokens(ctx context.Context) ([]api.APIToken, error) {
+	var tokens []api.APIToken
+	if err := c.do(ctx, http.MethodGet, api.APIPrefix+"/tokens", nil, &tokens); nil != err {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// CreateToken creates an API token, its Token is the secret to use with
+// New, which can't be retrieved later
+func (c *Client) CreateToken(ctx context.Context, req api.TokenRequest) (*api.APIToken, error) {
+	var token api.APIToken
+	if err := c.do(ctx, http.MethodPost, api.APIPrefix+"/tokens", req, &token); nil != err {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeToken revokes the API token id
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	resp, err := c.send(ctx, http.MethodDelete, api.APIPrefix+"/tokens/"+url.PathEscape(id), nil)
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	if http.StatusNoContent != resp.StatusCode {
+		return httpError(resp)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries lists the last deliveries of webhook, of every
+// webhook when empty
+func (c *Client) ListWebhookDeliveries(ctx context.Context, webhook string) ([]api.WebhookDelivery, error) {
+	p := api.APIPrefix + "/webhooks/deliveries"
+	if "" != webhook {
+		p += "?webhook=" + url.QueryEscape(webhook)
+	}
+	var deliveries []api.WebhookDelivery
+	if err := c.do(ctx, http.MethodGet, p, nil, &deliveries); nil != err {
+		return nil, err
+	}
+	return deliveries, nil
+}
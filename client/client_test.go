@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/rest"
+)
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if "Bearer secret" != r.Header.Get("Authorization") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/base/data":
+			json.NewEncoder(w).Encode(api.DataResponse{Destinations: map[string][]string{"media": {"movies"}}})
+		case "/base/move":
+			var req api.MoveRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(api.DataResponse{OpResponse: []api.MoveOpertationResponse{{Src: req.Src + "/" + req.Items[0], Operation: "move"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL+"/base/", "secret")
+	if nil != err {
+		t.Fatalf("could not create client %v", err)
+	}
+	data, err := c.Data(context.Background())
+	if nil != err || "movies" != data.Destinations["media"][0] {
+		t.Fatalf("unexpected data %v %v", data, err)
+	}
+	data, err = c.Move(context.Background(), api.MoveRequest{Src: "/dl", Items: []string{"a"}, Dest: "media/movies"})
+	if nil != err || 1 != len(data.OpResponse) || "/dl/a" != data.OpResponse[0].Src {
+		t.Fatalf("unexpected move response %v %v", data, err)
+	}
+
+	c, _ = New(server.URL+"/base", "wrong")
+	_, err = c.Data(context.Background())
+	var he *HTTPError
+	if !errors.As(err, &he) || http.StatusForbidden != he.StatusCode {
+		t.Fatalf("expected a forbidden HTTPError, got %v", err)
+	}
+
+	if _, err = New("ftp://host", ""); nil == err {
+		t.Fatalf("non http url should be refused")
+	}
+}
+
+// TestClientCoversSpec checks there is a Client method for every operation of
+// the OpenAPI document, named after its operationId.
+func TestClientCoversSpec(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(rest.OpenAPISpec(), &doc); nil != err {
+		t.Fatalf("invalid openapi.json %v", err)
+	}
+	typ := reflect.TypeOf(&Client{})
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			name := strings.ToUpper(op.OperationID[:1]) + op.OperationID[1:]
+			if _, ok := typ.MethodByName(name); !ok {
+				t.Fatalf("no Client.%s for %s %s", name, method, path)
+			}
+		}
+	}
+}
+
+func TestCreateOperation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.OperationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); nil != err || "move" != req.Operation {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(api.ErrorResponse{Error: api.APIError{Code: api.CodeBadRequest, Message: "operation should be move or copy"}})
+			return
+		}
+		op := api.Operation{ID: "1", Operation: req.Operation, Status: http.StatusMultiStatus, Results: []api.ItemResult{
+			{Item: req.Items[0], Status: http.StatusOK},
+			{Item: req.Items[1], Status: http.StatusNotFound, Error: &api.APIError{Code: api.CodeNotFound, Message: "item not found in source directory"}},
+		}}
+		w.WriteHeader(op.Status)
+		json.NewEncoder(w).Encode(op)
+	}))
+	defer server.Close()
+
+	c, _ := New(server.URL, "")
+	op, err := c.CreateOperation(context.Background(), api.OperationRequest{Operation: "move", Src: "/dl", Items: []string{"a", "b"}, Dest: "media/movies"})
+	var he *HTTPError
+	if nil == op || !errors.As(err, &he) || http.StatusMultiStatus != he.StatusCode || 2 != len(op.Results) {
+		t.Fatalf("a partial success should return the operation and a 207 error, got %v %v", op, err)
+	}
+	_, err = c.CreateOperation(context.Background(), api.OperationRequest{Operation: "link"})
+	if !errors.As(err, &he) || api.CodeBadRequest != he.Code {
+		t.Fatalf("expected a bad_request HTTPError, got %v", err)
+	}
+}
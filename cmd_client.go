@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/client"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// exit codes of the client and local subcommands
+const (
+	exitOk          = 0
+	exitItemsFailed = 1
+	exitUsage       = 2
+	exitServer      = 3
+	exitAuth        = 4
+)
+
+const clientUsage = `usage: remote-move client [flags] <command> [args]
+
+commands:
+  list                                     sources with their items and destinations
+  sources                                  sources with their items
+  destinations                             destinations with their ownership policy
+  move -src dir -dest root/subdir item...  move items
+  copy -src dir -dest root/subdir item...  copy items
+  tokens                                   API tokens
+  token create -name n -expires 720h -ops move[,copy] -src patterns -dest patterns
+                                           create an API token, its secret is printed once
+  token revoke id                          revoke an API token
+  deliveries [webhook]                     last webhook deliveries
+
+exit codes: 0 ok, 1 some items failed, 2 usage, 3 server or connection error,
+4 not authorized
+
+flags:
+`
+
+// itemResult is the outcome of moving/copying a single item
+type itemResult struct {
+	Item      string `json:"item"`
+	Operation string `json:"operation"`
+	Ok        bool   `json:"ok"`
+	Message   string `json:"message,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+func envOr(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return def
+}
+
+func exitCodeOf(err error) int {
+	var he *client.HTTPError
+	if errors.As(err, &he) && (http.StatusUnauthorized == he.StatusCode || http.StatusForbidden == he.StatusCode) {
+		return exitAuth
+	}
+	return exitServer
+}
+
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), clientUsage)
+		fs.PrintDefaults()
+	}
+	url := fs.String("url", envOr(conf.EnvPrefix+"URL", "http://127.0.0.1:8089"), "server url with its base path, or unix:/path.sock (env "+conf.EnvPrefix+"URL)")
+	token := fs.String("token", os.Getenv(conf.EnvPrefix+"TOKEN"), "API token (env "+conf.EnvPrefix+"TOKEN)")
+	user := fs.String("user", os.Getenv(conf.EnvPrefix+"USER"), "log in as this user, the password is read from "+conf.EnvPrefix+"PASSWORD and the code of a second factor from "+conf.EnvPrefix+"TOTP (env "+conf.EnvPrefix+"USER)")
+	cert := fs.String("cert", os.Getenv(conf.EnvPrefix+"CERT"), "client certificate, pem, for servers requiring one (env "+conf.EnvPrefix+"CERT)")
+	key := fs.String("key", os.Getenv(conf.EnvPrefix+"KEY"), "key of the client certificate, pem (env "+conf.EnvPrefix+"KEY)")
+	caCert := fs.String("cacert", os.Getenv(conf.EnvPrefix+"CACERT"), "CA of the server certificate, pem, when it is not a public one (env "+conf.EnvPrefix+"CACERT)")
+	output := fs.String("o", "table", "output format, table or json")
+	if err := fs.Parse(args); nil != err {
+		return exitUsage
+	}
+	if "table" != *output && "json" != *output {
+		fmt.Fprintln(os.Stderr, "invalid output format "+*output)
+		return exitUsage
+	}
+	if 0 == fs.NArg() {
+		fs.Usage()
+		return exitUsage
+	}
+
+	c, err := client.New(*url, *token)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if "" != *cert || "" != *caCert {
+		config, err := clientTLS(*cert, *key, *caCert)
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		c.UseTLS(config)
+	}
+	ctx := context.Background()
+	jsonOut := "json" == *output
+	if "" != *user {
+		if _, err = c.Login(ctx, *user, os.Getenv(conf.EnvPrefix+"PASSWORD"), os.Getenv(conf.EnvPrefix+"TOTP")); nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		defer c.Logout(ctx)
+	}
+
+	switch cmd := fs.Arg(0); cmd {
+	case "list", "sources", "destinations":
+		data, err := c.Data(ctx)
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		printData(cmd, data, jsonOut)
+		if data.ListingErrors {
+			fmt.Fprintln(os.Stderr, "the server had errors listing the sources or destinations")
+			return exitServer
+		}
+		return exitOk
+	case "move", "copy":
+		return runClientOp(ctx, c, cmd, fs.Args()[1:], jsonOut)
+	case "tokens":
+		tokens, err := c.ListTokens(ctx)
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		printTokens(tokens, jsonOut)
+		return exitOk
+	case "token":
+		return runClientToken(ctx, c, fs.Args()[1:], jsonOut)
+	case "deliveries":
+		deliveries, err := c.ListWebhookDeliveries(ctx, fs.Arg(1))
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		printDeliveries(deliveries, jsonOut)
+		return exitOk
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command "+cmd)
+		fs.Usage()
+		return exitUsage
+	}
+}
+
+// clientTLS loads the client certificate and the CA of the server, if given
+func clientTLS(cert, key, caCert string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if "" != cert {
+		if "" == key {
+			key = cert
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if nil != err {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if "" != caCert {
+		pem, err := os.ReadFile(caCert)
+		if nil != err {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate in " + caCert)
+		}
+	}
+	return config, nil
+}
+
+func runClientOp(ctx context.Context, c *client.Client, op string, args []string, jsonOut bool) int {
+	fs := flag.NewFlagSet(op, flag.ContinueOnError)
+	src := fs.String("src", "", "source directory")
+	dest := fs.String("dest", "", "destination, root/subdir")
+	follow := fs.Bool("follow", false, "send the items one at a time, reporting each as it is done")
+	if err := fs.Parse(args); nil != err {
+		return exitUsage
+	}
+	if "" == *src || "" == *dest || 0 == fs.NArg() {
+		fmt.Fprintln(os.Stderr, "usage: remote-move client "+op+" -src dir -dest root/subdir item...")
+		return exitUsage
+	}
+
+	call := c.Move
+	if "copy" == op {
+		call = c.Copy
+	}
+	batches := [][]string{fs.Args()}
+	if *follow {
+		batches = make([][]string, 0, fs.NArg())
+		for _, item := range fs.Args() {
+			batches = append(batches, []string{item})
+		}
+	}
+
+	results := make([]itemResult, 0, fs.NArg())
+	for n, items := range batches {
+		data, err := call(ctx, api.MoveRequest{Src: *src, Items: items, Dest: *dest})
+		if nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		batch := opResults(op, *src, items, data.OpResponse)
+		if *follow && !jsonOut {
+			for _, r := range batch {
+				fmt.Printf("[%d/%d] %s\n", n+1, len(batches), formatResult(r))
+			}
+		}
+		results = append(results, batch...)
+	}
+
+	if jsonOut {
+		printJSON(results)
+	} else if !*follow {
+		for _, r := range results {
+			fmt.Println(formatResult(r))
+		}
+	}
+	for _, r := range results {
+		if !r.Ok {
+			return exitItemsFailed
+		}
+	}
+	return exitOk
+}
+
+func runClientToken(ctx context.Context, c *client.Client, args []string, jsonOut bool) int {
+	if 0 == len(args) || ("create" != args[0] && "revoke" != args[0]) {
+		fmt.Fprintln(os.Stderr, "usage: remote-move client token create|revoke ...")
+		return exitUsage
+	}
+	if "revoke" == args[0] {
+		if 2 != len(args) {
+			fmt.Fprintln(os.Stderr, "usage: remote-move client token revoke id")
+			return exitUsage
+		}
+		if err := c.RevokeToken(ctx, args[1]); nil != err {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeOf(err)
+		}
+		return exitOk
+	}
+
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the token")
+	expires := fs.Duration("expires", 30*24*time.Hour, "how long the token is valid")
+	ops := fs.String("ops", "", "comma separated operations, ex: move,copy")
+	src := fs.String("src", "", "comma separated patterns of the allowed source directories")
+	dest := fs.String("dest", "", "comma separated patterns of the allowed destinations, root or root/subdir")
+	if err := fs.Parse(args[1:]); nil != err {
+		return exitUsage
+	}
+	if "" == *name || "" == *ops || "" == *src || "" == *dest || *expires <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: remote-move client token create -name n -expires 720h -ops move[,copy] -src patterns -dest patterns")
+		return exitUsage
+	}
+	token, err := c.CreateToken(ctx, api.TokenRequest{
+		Name:         *name,
+		Expires:      time.Now().Add(*expires).Truncate(time.Second),
+		Operations:   splitList(*ops),
+		Sources:      splitList(*src),
+		Destinations: splitList(*dest),
+	})
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeOf(err)
+	}
+	if jsonOut {
+		printJSON(token)
+	} else {
+		fmt.Println(token.Token)
+		fmt.Fprintln(os.Stderr, "token "+token.ID+" expires "+token.Expires.Format(time.RFC3339)+", its secret is not shown again")
+	}
+	return exitOk
+}
+
+func splitList(s string) []string {
+	ret := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); "" != v {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func printTokens(tokens []api.APIToken, jsonOut bool) {
+	if jsonOut {
+		printJSON(tokens)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tOPERATIONS\tSOURCES\tDESTINATIONS\tEXPIRES\tLAST USED")
+	for _, t := range tokens {
+		lastUsed := "never"
+		if nil != t.LastUsed {
+			lastUsed = t.LastUsed.Format(time.RFC3339)
+		}
+		fmt.Fprintln(tw, strings.Join([]string{t.ID, t.Name, strings.Join(t.Operations, ","), strings.Join(t.Sources, ","), strings.Join(t.Destinations, ","), t.Expires.Format(time.RFC3339), lastUsed}, "\t"))
+	}
+	tw.Flush()
+}
+
+func printDeliveries(deliveries []api.WebhookDelivery, jsonOut bool) {
+	if jsonOut {
+		printJSON(deliveries)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tWEBHOOK\tEVENT\tEVENT ID\tSTATUS\tATTEMPTS\tANSWER\tUPDATED\tERROR")
+	for _, d := range deliveries {
+		fmt.Fprintln(tw, strings.Join([]string{d.ID, d.Webhook, d.Event, d.EventID, d.Status, strconv.Itoa(d.Attempts), strconv.Itoa(d.ResponseStatus), d.Updated.Format(time.RFC3339), d.Error}, "\t"))
+	}
+	tw.Flush()
+}
+
+// opResults matches the failures reported by the server to the items sent
+func opResults(op, src string, items []string, failures []api.MoveOpertationResponse) []itemResult {
+	results := make([]itemResult, 0, len(items))
+	for _, item := range items {
+		r := itemResult{Item: item, Operation: op, Ok: true}
+		for _, f := range failures {
+			if f.Src == src+"/"+item {
+				r.Ok = false
+				if "" != r.Message {
+					r.Message += "; "
+				}
+				r.Message += f.Operation + ": " + f.Message
+				r.Code = f.Code
+				if f.Operation != op {
+					r.Path = f.Dest
+				}
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func formatResult(r itemResult) string {
+	if r.Ok {
+		return r.Operation + " ok: " + r.Item
+	}
+	return r.Operation + " failed: " + r.Item + ": " + r.Message
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func printData(cmd string, data *api.DataResponse, jsonOut bool) {
+	if jsonOut {
+		switch cmd {
+		case "sources":
+			printJSON(data.SrcDirAndItsContents)
+		case "destinations":
+			printJSON(struct {
+				Destinations        map[string][]string           `json:"destinations"`
+				DestinationPolicies map[string]api.PolicyResponse `json:"destinationPolicies"`
+			}{data.Destinations, data.DestinationPolicies})
+		default:
+			printJSON(data)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if "destinations" != cmd {
+		fmt.Fprintln(tw, "SOURCE\tITEM")
+		for _, dir := range sortedKeys(data.SrcDirAndItsContents) {
+			for _, item := range data.SrcDirAndItsContents[dir] {
+				fmt.Fprintln(tw, dir+"\t"+item)
+			}
+		}
+	}
+	if "list" == cmd {
+		fmt.Fprintln(tw)
+	}
+	if "sources" != cmd {
+		fmt.Fprintln(tw, "DESTINATION\tOWNER\tFILE MODE\tDIR MODE\tPOLICY")
+		for _, root := range sortedKeys(data.Destinations) {
+			for _, d := range data.Destinations[root] {
+				where := root + "/" + d
+				p := data.DestinationPolicies[where]
+				fmt.Fprintln(tw, strings.Join([]string{where, p.Owner, p.FileMode, p.DirMode, p.Match}, "\t"))
+			}
+		}
+	}
+	tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
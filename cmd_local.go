@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/conf"
+	"github.com/shoaib42/remote-move/io"
+	"github.com/shoaib42/remote-move/rest"
+)
+
+const localUsage = `usage: remote-move mv|cp [flags] <src-item>... <root/subdir>
+
+moves or copies items of the configured srcDirs to a destination and chowns
+them, with the exact same checks and policies as the web ui, without a server.
+
+exit codes: 0 ok, 1 some items failed, 2 usage or configuration
+
+flags:
+`
+
+// runLocal runs mv or cp through the io package directly
+func runLocal(op string, args []string) int {
+	fs := flag.NewFlagSet(op, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), localUsage)
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", defaultConfigPath(), "path to the YAML configuration file (env "+conf.EnvPrefix+"CONFIG)")
+	output := fs.String("o", "table", "output format, table or json")
+	level := fs.String("log-level", "error", "debug, info, warn or error")
+	if err := fs.Parse(args); nil != err {
+		return exitUsage
+	}
+	if fs.NArg() < 2 || ("table" != *output && "json" != *output) {
+		fs.Usage()
+		return exitUsage
+	}
+	*configPath = *config
+	*logLevel = *level
+	if err := loadConfiguration(); nil != err {
+		fmt.Fprintln(os.Stderr, "failed to load configuration: "+err.Error())
+		return exitUsage
+	}
+
+	iohelper, err := io.NewIOHelper(conf.Confs.SrcDirs, conf.Confs.DestRoots, conf.Confs.ExcludeDirs, conf.Confs.DefaultPolicy(), conf.Confs.Policies)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	operation, do := "move", iohelper.DoMvChown
+	if "cp" == op {
+		operation, do = "copy", iohelper.DoCpChown
+	}
+	dest := fs.Arg(fs.NArg() - 1)
+	results := make([]itemResult, 0, fs.NArg()-1)
+	for _, item := range fs.Args()[:fs.NArg()-1] {
+		if abs, err := filepath.Abs(item); nil == err {
+			item = abs
+		}
+		from, what := localSource(filepath.Dir(item), conf.Confs.SrcDirs), filepath.Base(item)
+		results = append(results, localResults(operation, item, do(from, what, dest))...)
+	}
+
+	if "json" == *output {
+		printJSON(results)
+	} else {
+		for _, r := range results {
+			fmt.Println(formatResult(r))
+		}
+	}
+	for _, r := range results {
+		if !r.Ok {
+			return exitItemsFailed
+		}
+	}
+	return exitOk
+}
+
+// localSource is the entry of srcDirs that is dir once both are absolute and
+// clean, as the io package only knows the configured srcDirs, else dir
+func localSource(dir string, srcDirs []string) string {
+	for _, src := range srcDirs {
+		if abs, err := filepath.Abs(src); nil == err && abs == dir {
+			return src
+		}
+	}
+	return dir
+}
+
+func localResults(op, item string, err error) []itemResult {
+	if nil == err {
+		return []itemResult{{Item: item, Operation: op, Ok: true}}
+	}
+	var pe *io.PolicyError
+	if !errors.As(err, &pe) {
+		return []itemResult{{Item: item, Operation: op, Message: err.Error(), Code: rest.ErrorCode(err)}}
+	}
+	results := make([]itemResult, 0, len(pe.Failures))
+	for _, f := range pe.Failures {
+		results = append(results, itemResult{Item: item, Operation: op, Message: f.Op + ": " + f.Err.Error(), Code: api.CodeOwnershipFailed, Path: f.Path})
+	}
+	return results
+}
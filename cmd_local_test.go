@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestRunLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"downloads", "docs", "media/movies", "archive/docs"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+	for _, f := range []string{"downloads/film.mkv", "docs/a.pdf"} {
+		os.WriteFile(filepath.Join(dir, f), []byte("x"), 0644)
+	}
+	owner := strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	// srcDirs relative to the working directory, one with a trailing slash
+	yml := "srcDirs: [downloads/, ./docs]\nchownUsrGrp: \"" + owner + "\"\ndestRoots:\n  - {name: media, path: " + filepath.Join(dir, "media") + "}\n  - {name: archive, path: " + filepath.Join(dir, "archive") + "}\n"
+	config := filepath.Join(dir, "configuration.yaml")
+	if err := os.WriteFile(config, []byte(yml), 0600); nil != err {
+		t.Fatalf("could not write the configuration %v", err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	if code := runLocal("mv", []string{"-config", config, "-o", "json", "downloads/film.mkv", "media/movies"}); exitOk != code {
+		t.Fatalf("moving from a srcDir with a trailing slash should work, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "media/movies/film.mkv")); nil != err {
+		t.Fatalf("the item should be moved %v", err)
+	}
+	if code := runLocal("cp", []string{"-config", config, filepath.Join(dir, "docs/a.pdf"), "archive/docs"}); exitOk != code {
+		t.Fatalf("copying from a relative srcDir should work, got %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "archive/docs/a.pdf")); nil != err {
+		t.Fatalf("the item should be copied %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "docs/a.pdf")); nil != err {
+		t.Fatalf("the copied item should stay %v", err)
+	}
+	if code := runLocal("mv", []string{"-config", config, "configuration.yaml", "archive/docs"}); exitItemsFailed != code {
+		t.Fatalf("items out of the srcDirs should fail, got %d", code)
+	}
+}
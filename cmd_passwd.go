@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/shoaib42/remote-move/auth"
+)
+
+// runPasswd reads a password on stdin and prints its hash, for the password
+// of a user in the configuration
+func runPasswd(args []string) int {
+	if 0 != len(args) {
+		fmt.Fprintln(os.Stderr, "usage: remote-move passwd < password")
+		return exitUsage
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if "" == password {
+		fmt.Fprintln(os.Stderr, "no password given")
+		return exitUsage
+	}
+	hash, err := auth.HashPassword(password)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, err)
+		return exitServer
+	}
+	fmt.Println(hash)
+	return exitOk
+}
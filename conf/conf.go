@@ -1,52 +1,708 @@
 package conf
 
 import (
+	"errors"
 	"io/ioutil"
-	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
 
+// EnvPrefix is prepended to the environment variable name derived from the
+// yaml tag of every Configuration field, ex: srcDirs -> REMOTE_MOVE_SRC_DIRS
+const EnvPrefix = "REMOTE_MOVE_"
+
 type VoidT struct{}
 
+// DestRoot is a labeled directory whose subdirs are the move/copy destinations
+type DestRoot struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// OwnerInherit and ModeInherit take the owner/mode from the destination
+// directory the item is moved into.
+const (
+	OwnerInherit = "inherit"
+	ModeInherit  = "inherit"
+)
+
+// Policy is the ownership and permissions applied to whatever lands in the
+// destinations matching Match, a root/subdir glob (ex: media/movies, */docs)
+// or a bare root name for all of its subdirs. A nil SetgidDirs takes the one
+// of the configuration.
+type Policy struct {
+	Match      string `yaml:"match"`
+	Owner      string `yaml:"owner"`
+	FileMode   string `yaml:"fileMode"`
+	DirMode    string `yaml:"dirMode"`
+	SetgidDirs *bool  `yaml:"setgidDirs"`
+
+	// resolved from the above, names are looked up in PasswdFile and
+	// GroupFile, Uid and Gid are -1 when not set by the policy, a zero perm leaves the mode untouched.
+	Uid             int         `yaml:"-"`
+	Gid             int         `yaml:"-"`
+	InheritOwner    bool        `yaml:"-"`
+	FilePerm        os.FileMode `yaml:"-"`
+	DirPerm         os.FileMode `yaml:"-"`
+	InheritFileMode bool        `yaml:"-"`
+	InheritDirMode  bool        `yaml:"-"`
+}
+
+// Logging configures the level, format (logfmt, json) and output (stderr,
+// file, syslog) of the logs, the file output rotates at MaxSizeMB keeping
+// MaxBackups files.
+type Logging struct {
+	Level      string `yaml:"level"`
+	Format     string `yaml:"format"`
+	Output     string `yaml:"output"`
+	File       string `yaml:"file"`
+	MaxSizeMB  int    `yaml:"maxSizeMB"`
+	MaxBackups int    `yaml:"maxBackups"`
+	SyslogTag  string `yaml:"syslogTag"`
+}
+
+// Operations are what a role can be allowed to do. link, delete and mkdir
+// are reserved for operations the server does not offer yet, admin manages
+// the API tokens and metrics reads /metrics.
+var Operations = []string{"move", "copy", "link", "delete", "mkdir", "admin", "metrics"}
+
+// Role allows its Operations from the source directories matching Sources to
+// the destinations matching Destinations. Patterns are path.Match globs, * on
+// its own matches everything, a destination pattern without a / matches
+// every subdir of the roots with that name, as the policies do.
+type Role struct {
+	Name         string   `yaml:"name"`
+	Sources      []string `yaml:"sources"`
+	Destinations []string `yaml:"destinations"`
+	Operations   []string `yaml:"operations"`
+}
+
+// User logs in with Password, a hash from `remote-move passwd`, and gets
+// the permissions of its Roles.
+type User struct {
+	Name     string   `yaml:"name"`
+	Password string   `yaml:"password"`
+	Roles    []string `yaml:"roles"`
+}
+
+// Limits bounds what a client can take from the server. The rates are
+// requests per second per client ip, refilling a bucket of Burst requests,
+// for the listing (GET) and mutation (POST, ...) API routes. 0 disables a
+// limit.
+type Limits struct {
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout"`
+	ReadTimeout       time.Duration `yaml:"readTimeout"`
+	WriteTimeout      time.Duration `yaml:"writeTimeout"`
+	IdleTimeout       time.Duration `yaml:"idleTimeout"`
+	MaxBodyBytes      int64         `yaml:"maxBodyBytes"`
+	MaxItems          int           `yaml:"maxItems"`
+	ListingRate       float64       `yaml:"listingRate"`
+	ListingBurst      int           `yaml:"listingBurst"`
+	MutationRate      float64       `yaml:"mutationRate"`
+	MutationBurst     int           `yaml:"mutationBurst"`
+}
+
+// TwoFactor is the TOTP second factor users can enroll. RequireOutsideLAN
+// makes it mandatory for clients outside LANCIDRs, by default the loopback,
+// private and link local ranges.
+type TwoFactor struct {
+	RequireOutsideLAN bool     `yaml:"requireOutsideLAN"`
+	LANCIDRs          []string `yaml:"lanCIDRs"`
+	Issuer            string   `yaml:"issuer"`
+}
+
+// TLS serves https on the tcp listeners with Cert and Key. With ClientCA the
+// clients can present a certificate it signed, which RequireClientCert makes
+// mandatory, and ClientCerts maps their subjects to users or roles. With
+// ClientCertsBypassCIDRs such clients are let in from anywhere, else they
+// are also checked against allowedCIDRs.
+type TLS struct {
+	Cert                   string       `yaml:"cert"`
+	Key                    string       `yaml:"key"`
+	ClientCA               string       `yaml:"clientCA"`
+	RequireClientCert      bool         `yaml:"requireClientCert"`
+	ClientCertsBypassCIDRs bool         `yaml:"clientCertsBypassCIDRs"`
+	ClientCerts            []ClientCert `yaml:"clientCerts"`
+}
+
+// ClientCert maps the client certificates of Subject, their common name or
+// full subject (CN=phone,O=home), to a User or to Roles
+type ClientCert struct {
+	Subject string   `yaml:"subject"`
+	User    string   `yaml:"user"`
+	Roles   []string `yaml:"roles"`
+}
+
+// ForwardAuth trusts the user and groups an authenticating reverse proxy,
+// ex: Authelia, sets in UserHeader and GroupsHeader, but only on requests
+// coming from Proxies, trustedProxies by default. Groups maps the groups to
+// roles, a user also having the roles of the configured user of that name.
+type ForwardAuth struct {
+	Enabled      bool         `yaml:"enabled"`
+	Proxies      []string     `yaml:"proxies"`
+	UserHeader   string       `yaml:"userHeader"`
+	GroupsHeader string       `yaml:"groupsHeader"`
+	Groups       []GroupRoles `yaml:"groups"`
+}
+
+// GroupRoles gives Roles to the members of a forward auth Group
+type GroupRoles struct {
+	Group string   `yaml:"group"`
+	Roles []string `yaml:"roles"`
+}
+
+// Events are what webhooks can be fired on, the outcome of an operation.
+// delete is reserved for when the server offers it.
+var Events = []string{"move.succeeded", "move.failed", "copy.succeeded", "copy.failed", "delete.succeeded", "delete.failed"}
+
+// Webhook posts Body, a text/template of the event, JSON by default, to URL
+// on Events, path.Match patterns as move.* or *.failed, all when empty. With
+// a Secret the time and the HMAC-SHA256 of the time and body are sent in
+// SignatureHeader. A delivery is tried Attempts times, Backoff apart doubling
+// every time, each attempt taking Timeout at most.
+type Webhook struct {
+	Name            string        `yaml:"name"`
+	URL             string        `yaml:"url"`
+	Events          []string      `yaml:"events"`
+	Body            string        `yaml:"body"`
+	ContentType     string        `yaml:"contentType"`
+	Secret          string        `yaml:"secret"`
+	SignatureHeader string        `yaml:"signatureHeader"`
+	Timeout         time.Duration `yaml:"timeout"`
+	Attempts        int           `yaml:"attempts"`
+	Backoff         time.Duration `yaml:"backoff"`
+}
+
 type Configuration struct {
-	SrcDirs        []string `yaml:"srcDirs"`
-	DestRootDir    string   `yaml:"destRootDir"`
-	ExcludeDirs    []string `yaml:"excludeDirs"`
-	AllowedCIDRs   []string `yaml:"allowedCIDRs"`
-	ServerBindAddr string   `yaml:"serverBindAddr"`
-	ServerBindPort string   `yaml:"serverBindPort"`
-	ChownUsrGrp    string   `yaml:"chownUsrGrp"`
+	SrcDirs        []string      `yaml:"srcDirs"`
+	DestRootDir    string        `yaml:"destRootDir"`
+	DestRoots      []DestRoot    `yaml:"destRoots"`
+	ExcludeDirs    []string      `yaml:"excludeDirs"`
+	AllowedCIDRs   []string      `yaml:"allowedCIDRs"`
+	DeniedCIDRs    []string      `yaml:"deniedCIDRs"`
+	TrustedProxies []string      `yaml:"trustedProxies"`
+	AllowedOrigins []string      `yaml:"allowedOrigins"`
+	ServerBindAddr string        `yaml:"serverBindAddr"`
+	ServerBindPort string        `yaml:"serverBindPort"`
+	Listen         []string      `yaml:"listen"`
+	SocketMode     string        `yaml:"socketMode"`
+	SocketOwner    string        `yaml:"socketOwner"`
+	ChownUsrGrp    string        `yaml:"chownUsrGrp"`
+	FileMode       string        `yaml:"fileMode"`
+	DirMode        string        `yaml:"dirMode"`
+	Umask          string        `yaml:"umask"`
+	SetgidDirs     bool          `yaml:"setgidDirs"`
+	Policies       []Policy      `yaml:"policies"`
+	RunAs          string        `yaml:"runAs"`
+	Log            Logging       `yaml:"log"`
+	AssetsDir      string        `yaml:"assetsDir"`
+	BasePath       string        `yaml:"basePath"`
+	Limits         Limits        `yaml:"limits"`
+	Roles          []Role        `yaml:"roles"`
+	Users          []User        `yaml:"users"`
+	AnonymousRoles []string      `yaml:"anonymousRoles"`
+	SessionTTL     time.Duration `yaml:"sessionTTL"`
+	TwoFactor      TwoFactor     `yaml:"twoFactor"`
+	StateDir       string        `yaml:"stateDir"`
+	TLS            TLS           `yaml:"tls"`
+	ForwardAuth    ForwardAuth   `yaml:"forwardAuth"`
+	Webhooks       []Webhook     `yaml:"webhooks"`
 	Uid            int
 	Gid            int
+	FilePerm       os.FileMode `yaml:"-"`
+	DirPerm        os.FileMode `yaml:"-"`
+	RunAsUid       int         `yaml:"-"`
+	RunAsGid       int         `yaml:"-"`
+	SocketPerm     os.FileMode `yaml:"-"`
+	SocketUid      int         `yaml:"-"`
+	SocketGid      int         `yaml:"-"`
 }
 
 var Void VoidT
 
 var Confs Configuration
 
-func LoadConfiguration(filepath string) error {
+// defaults are the values used for anything not set by the configuration
+// file, the environment or the command line.
+func defaults() Configuration {
+	return Configuration{
+		ServerBindAddr: "127.0.0.1",
+		ServerBindPort: "8089",
+		Log: Logging{
+			Level:      "info",
+			Format:     "logfmt",
+			Output:     "stderr",
+			MaxSizeMB:  10,
+			MaxBackups: 3,
+		},
+		SessionTTL: 12 * time.Hour,
+		TwoFactor:  TwoFactor{Issuer: "remote-move"},
+		ForwardAuth: ForwardAuth{
+			UserHeader:   "Remote-User",
+			GroupsHeader: "Remote-Groups",
+		},
+		StateDir: "/var/lib/remote-move",
+		Limits: Limits{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			MaxBodyBytes:      1 << 20,
+			MaxItems:          1000,
+			ListingRate:       10,
+			ListingBurst:      50,
+			MutationRate:      1,
+			MutationBurst:     20,
+		},
+	}
+}
+
+// LoadConfiguration fills Confs from, in increasing order of precedence, the
+// built-in defaults, the YAML file at filepath, the REMOTE_MOVE_* environment
+// variables and what flags, the command line flags if not nil, sets. Confs is
+// only replaced when the result resolves.
+func LoadConfiguration(filepath string, flags func(*Configuration)) error {
 	yamlFile, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return err
 	}
 
-	err = yaml.Unmarshal(yamlFile, &Confs)
+	c := defaults()
+	err = yaml.Unmarshal(yamlFile, &c)
 	if err != nil {
 		return err
 	}
 
-	uid_gid := strings.Split(Confs.ChownUsrGrp, ":")
-	if 2 != len(uid_gid) {
-		log.Fatal("failed to parse chownUsrGrp, should be of format Uid:Gid (127:127)")
+	if err = c.ApplyEnv(os.LookupEnv); nil != err {
+		return err
+	}
+
+	c.relativeTo(filepath)
+	if nil != flags {
+		flags(&c)
+	}
+	if err = c.Resolve(); nil != err {
+		return err
+	}
+	Confs = c
+	return nil
+}
+
+// relativeTo makes the assets and state dirs, and the tls files, relative to
+// the directory of the configuration file instead of the working directory.
+func (c *Configuration) relativeTo(configFile string) {
+	if "" != c.AssetsDir && !filepath.IsAbs(c.AssetsDir) {
+		c.AssetsDir = filepath.Join(filepath.Dir(configFile), c.AssetsDir)
+	}
+	for _, f := range []*string{&c.StateDir, &c.TLS.Cert, &c.TLS.Key, &c.TLS.ClientCA} {
+		if "" != *f && !filepath.IsAbs(*f) {
+			*f = filepath.Join(filepath.Dir(configFile), *f)
+		}
+	}
+}
+
+// Resolve derives the computed fields (Uid, Gid, perms) from the configured
+// ones and validates the destination roots. User and group names are looked up
+// again on every call, so that a reload picks up changed accounts.
+func (c *Configuration) Resolve() error {
+	if err := c.validateDestRoots(); nil != err {
+		return err
+	}
+
+	var err error
+	if c.Uid, c.Gid, err = ParseOwner(c.ChownUsrGrp); nil != err {
+		return errors.New("chownUsrGrp: " + err.Error())
+	}
+
+	c.RunAsUid, c.RunAsGid = -1, -1
+	if "" != c.RunAs {
+		if c.RunAsUid, c.RunAsGid, err = ParseOwner(c.RunAs); nil != err {
+			return errors.New("runAs: " + err.Error())
+		}
+	}
+
+	c.SocketUid, c.SocketGid = -1, -1
+	if "" != c.SocketOwner {
+		if c.SocketUid, c.SocketGid, err = ParseOwner(c.SocketOwner); nil != err {
+			return errors.New("socketOwner: " + err.Error())
+		}
+	}
+	var inherit bool
+	if c.SocketPerm, inherit, err = parseMode(c.SocketMode); nil != err || inherit {
+		return errors.New("socketMode should be octal permissions (0660), provided : " + c.SocketMode)
+	}
+
+	if err = c.resolveModes(); nil != err {
+		return err
+	}
+
+	for n := range c.Policies {
+		if err = c.Policies[n].Resolve(); nil != err {
+			return err
+		}
+	}
+	if err = c.resolveWebhooks(); nil != err {
+		return err
+	}
+	return c.validateAccess()
+}
+
+// resolveWebhooks checks the webhooks have a unique name, an http(s) url and
+// known events, and fills in the defaults.
+func (c *Configuration) resolveWebhooks() error {
+	names := make(map[string]bool, len(c.Webhooks))
+	for n := range c.Webhooks {
+		w := &c.Webhooks[n]
+		if "" == w.Name || names[w.Name] {
+			return errors.New("webhook names should be unique and not empty: " + w.Name)
+		}
+		names[w.Name] = true
+		if u, err := url.Parse(w.URL); nil != err || ("http" != u.Scheme && "https" != u.Scheme) || "" == u.Host {
+			return errors.New("webhook " + w.Name + ": url should be http(s)://host/..., provided : " + w.URL)
+		}
+		for _, e := range w.Events {
+			if !slices.ContainsFunc(Events, func(known string) bool { ok, _ := path.Match(e, known); return ok }) {
+				return errors.New("webhook " + w.Name + ": unknown event " + e + ", should match one of " + strings.Join(Events, ", "))
+			}
+		}
+		if w.Attempts < 0 || w.Timeout < 0 || w.Backoff < 0 {
+			return errors.New("webhook " + w.Name + ": attempts, timeout and backoff can't be negative")
+		}
+		if "" == w.ContentType {
+			w.ContentType = "application/json"
+		}
+		if "" == w.SignatureHeader {
+			w.SignatureHeader = "X-Remote-Move-Signature"
+		}
+		if 0 == w.Timeout {
+			w.Timeout = 10 * time.Second
+		}
+		if 0 == w.Attempts {
+			w.Attempts = 4
+		}
+		if 0 == w.Backoff {
+			w.Backoff = time.Second
+		}
+	}
+	return nil
+}
+
+// validateAccess checks the roles are unique, allow known operations, and
+// that users and anonymousRoles only refer to defined roles.
+func (c *Configuration) validateAccess() error {
+	roles := make(map[string]bool, len(c.Roles))
+	for _, r := range c.Roles {
+		if "" == r.Name || roles[r.Name] {
+			return errors.New("role names should be unique and not empty: " + r.Name)
+		}
+		roles[r.Name] = true
+		for _, op := range r.Operations {
+			if !slices.Contains(Operations, op) {
+				return errors.New("role " + r.Name + ": unknown operation " + op + ", should be one of " + strings.Join(Operations, ", "))
+			}
+		}
+		for _, p := range append(append([]string(nil), r.Sources...), r.Destinations...) {
+			if _, err := path.Match(p, ""); nil != err {
+				return errors.New("role " + r.Name + ": invalid pattern " + p)
+			}
+		}
+	}
+	for _, name := range c.AnonymousRoles {
+		if !roles[name] {
+			return errors.New("anonymousRoles: unknown role " + name)
+		}
+	}
+	users := make(map[string]bool, len(c.Users))
+	for _, u := range c.Users {
+		if "" == u.Name || users[u.Name] {
+			return errors.New("user names should be unique and not empty: " + u.Name)
+		}
+		users[u.Name] = true
+		if "" == u.Password {
+			return errors.New("user " + u.Name + " has no password")
+		}
+		for _, name := range u.Roles {
+			if !roles[name] {
+				return errors.New("user " + u.Name + ": unknown role " + name)
+			}
+		}
+	}
+	if c.TwoFactor.RequireOutsideLAN && 0 == len(c.Users) {
+		return errors.New("twoFactor.requireOutsideLAN needs users")
+	}
+	if err := c.validateForwardAuth(roles); nil != err {
+		return err
+	}
+	return c.validateTLS(roles, users)
+}
+
+// validateForwardAuth checks the forward auth headers only come from proxies
+// and that the groups map to defined roles.
+func (c *Configuration) validateForwardAuth(roles map[string]bool) error {
+	f := c.ForwardAuth
+	if !f.Enabled {
+		return nil
+	}
+	switch {
+	case 0 == len(f.Proxies) && 0 == len(c.TrustedProxies):
+		return errors.New("forwardAuth needs proxies or trustedProxies")
+	case "" == f.UserHeader:
+		return errors.New("forwardAuth: userHeader can't be empty")
+	}
+	for _, g := range f.Groups {
+		if "" == g.Group {
+			return errors.New("forwardAuth: group without a name")
+		}
+		for _, name := range g.Roles {
+			if !roles[name] {
+				return errors.New("forwardAuth: group " + g.Group + ": unknown role " + name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTLS checks the tls settings go together and that the client
+// certificates map to defined users or roles.
+func (c *Configuration) validateTLS(roles, users map[string]bool) error {
+	t := c.TLS
+	switch {
+	case ("" == t.Cert) != ("" == t.Key):
+		return errors.New("tls: cert and key go together")
+	case "" != t.ClientCA && "" == t.Cert:
+		return errors.New("tls: clientCA needs a cert and key")
+	case "" == t.ClientCA && (t.RequireClientCert || t.ClientCertsBypassCIDRs || 0 != len(t.ClientCerts)):
+		return errors.New("tls: client certificates need a clientCA")
+	}
+	for _, cc := range t.ClientCerts {
+		if "" == cc.Subject || ("" == cc.User) == (0 == len(cc.Roles)) {
+			return errors.New("tls: client cert " + cc.Subject + " needs a subject and either a user or roles")
+		}
+		if "" != cc.User && !users[cc.User] {
+			return errors.New("tls: client cert " + cc.Subject + ": unknown user " + cc.User)
+		}
+		for _, name := range cc.Roles {
+			if !roles[name] {
+				return errors.New("tls: client cert " + cc.Subject + ": unknown role " + name)
+			}
+		}
+	}
+	return nil
+}
+
+// Resolve parses Owner, FileMode and DirMode into the resolved fields.
+func (p *Policy) Resolve() error {
+	if "" == p.Match {
+		return errors.New("policy without match")
+	}
+	if _, err := path.Match(p.Match, ""); nil != err {
+		return errors.New("policy " + p.Match + ": invalid match pattern")
+	}
+
+	p.Uid, p.Gid, p.InheritOwner = -1, -1, false
+	switch p.Owner {
+	case "":
+	case OwnerInherit:
+		p.InheritOwner = true
+	default:
+		var err error
+		if p.Uid, p.Gid, err = ParseOwner(p.Owner); nil != err {
+			return errors.New("policy " + p.Match + ": owner " + err.Error())
+		}
+	}
+
+	var err error
+	if p.FilePerm, p.InheritFileMode, err = parseMode(p.FileMode); nil != err {
+		return errors.New("policy " + p.Match + ": fileMode " + err.Error())
+	}
+	if p.DirPerm, p.InheritDirMode, err = parseMode(p.DirMode); nil != err {
+		return errors.New("policy " + p.Match + ": dirMode " + err.Error())
+	}
+	return nil
+}
+
+// resolveModes sets FilePerm and DirPerm from fileMode and dirMode, falling
+// back to 0666 and 0777 masked by umask when a umask is set.
+func (c *Configuration) resolveModes() error {
+	var err error
+	var inherit bool
+	if c.FilePerm, inherit, err = parseMode(c.FileMode); nil != err || inherit {
+		return errors.New("fileMode should be octal permissions (0644), provided : " + c.FileMode)
+	}
+	if c.DirPerm, inherit, err = parseMode(c.DirMode); nil != err || inherit {
+		return errors.New("dirMode should be octal permissions (0755), provided : " + c.DirMode)
+	}
+	if "" == c.Umask {
+		return nil
+	}
+	umask, inherit, err := parseMode(c.Umask)
+	if nil != err || inherit {
+		return errors.New("umask should be octal (0022), provided : " + c.Umask)
+	}
+	if 0 == c.FilePerm {
+		c.FilePerm = 0666 &^ umask
+	}
+	if 0 == c.DirPerm {
+		c.DirPerm = 0777 &^ umask
+	}
+	return nil
+}
+
+// DefaultPolicy is what applies to destinations no policy matches, and fills
+// in whatever a matching policy leaves out.
+func (c *Configuration) DefaultPolicy() Policy {
+	setgid := c.SetgidDirs
+	return Policy{
+		Uid:        c.Uid,
+		Gid:        c.Gid,
+		FilePerm:   c.FilePerm,
+		DirPerm:    c.DirPerm,
+		SetgidDirs: &setgid,
+	}
+}
+
+// Setgid reports if the directories get setgid
+func (p *Policy) Setgid() bool {
+	return nil != p.SetgidDirs && *p.SetgidDirs
+}
+
+// parseMode parses an octal permission string such as 0644
+func parseMode(mode string) (os.FileMode, bool, error) {
+	switch mode {
+	case "":
+		return 0, false, nil
+	case ModeInherit:
+		return 0, true, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if nil != err || m > 0777 {
+		return 0, false, errors.New("should be octal permissions (0644) or " + ModeInherit + ", provided : " + mode)
+	}
+	return os.FileMode(m), false, nil
+}
+
+// EnvName returns the environment variable overriding the field with the
+// given yaml tag.
+func EnvName(yamlTag string) string {
+	var sb strings.Builder
+	sb.WriteString(EnvPrefix)
+	prev := rune(0)
+	for _, r := range yamlTag {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			sb.WriteRune('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+		prev = r
+	}
+	return sb.String()
+}
+
+// ApplyEnv overrides every field having a yaml tag with the value of its
+// environment variable, if set. Lists of strings are comma separated, other
+// non scalar values are parsed as (flow style) YAML.
+func (c *Configuration) ApplyEnv(lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for n := 0; n < t.NumField(); n++ {
+		tag := strings.Split(t.Field(n).Tag.Get("yaml"), ",")[0]
+		if "" == tag || "-" == tag {
+			continue
+		}
+		name := EnvName(tag)
+		val, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(v.Field(n), val); nil != err {
+			return errors.New("invalid value for " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+func setFromString(f reflect.Value, val string) error {
+	if reflect.TypeOf(time.Duration(0)) == f.Type() {
+		d, err := time.ParseDuration(val)
+		if nil != err {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if nil != err {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(val, 0, 64)
+		if nil != err {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(val, 64)
+		if nil != err {
+			return err
+		}
+		f.SetFloat(x)
+	case reflect.Slice:
+		if reflect.String == f.Type().Elem().Kind() {
+			list := make([]string, 0)
+			for _, s := range strings.Split(val, ",") {
+				if s = strings.TrimSpace(s); "" != s {
+					list = append(list, s)
+				}
+			}
+			f.Set(reflect.ValueOf(list))
+			return nil
+		}
+		fallthrough
+	default:
+		return yaml.Unmarshal([]byte(val), f.Addr().Interface())
+	}
+	return nil
+}
+
+// validateDestRoots folds the legacy destRootDir into DestRoots, named after
+// its last path element, and checks the names are usable and unique.
+func (c *Configuration) validateDestRoots() error {
+	roots := make([]DestRoot, 0, len(c.DestRoots)+1)
+	roots = append(roots, c.DestRoots...)
+	if "" != c.DestRootDir {
+		roots = append(roots, DestRoot{Name: filepath.Base(c.DestRootDir), Path: c.DestRootDir})
 	}
-	if Confs.Uid, err = strconv.Atoi(uid_gid[0]); nil != err {
-		log.Fatal("failed to parse usr_id from chownUsrGrp, provided : " + uid_gid[0] + " should numeric int value")
+	if 0 == len(roots) {
+		return errors.New("no destination root configured, set destRoots or destRootDir")
 	}
-	if Confs.Gid, err = strconv.Atoi(uid_gid[1]); nil != err {
-		log.Fatal("failed to parse grp_id from chownUsrGrp, provided : " + uid_gid[1] + " should numeric int value")
+
+	seen := make(map[string]VoidT, len(roots))
+	for _, r := range roots {
+		if "" == r.Name || strings.Contains(r.Name, "/") {
+			return errors.New("invalid destination root name \"" + r.Name + "\", must be non empty and cannot contain /")
+		}
+		if "" == r.Path {
+			return errors.New("destination root " + r.Name + " has no path")
+		}
+		if _, dup := seen[r.Name]; dup {
+			return errors.New("destination root name " + r.Name + " is used more than once")
+		}
+		seen[r.Name] = Void
 	}
+	c.DestRoots = roots
+	c.DestRootDir = ""
 	return nil
 }
@@ -0,0 +1,188 @@
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	expect := map[string]string{
+		"srcDirs":        "REMOTE_MOVE_SRC_DIRS",
+		"allowedCIDRs":   "REMOTE_MOVE_ALLOWED_CIDRS",
+		"chownUsrGrp":    "REMOTE_MOVE_CHOWN_USR_GRP",
+		"serverBindPort": "REMOTE_MOVE_SERVER_BIND_PORT",
+	}
+	for tag, env := range expect {
+		if got := EnvName(tag); got != env {
+			t.Fatalf("env name for %s should be %s, got %s", tag, env, got)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "configuration.yaml")
+	yml := "srcDirs:\n  - /a\nserverBindPort: 9000\nserverBindAddr: 10.0.0.1\nchownUsrGrp: 1:2\ndestRootDir: /srv/media\nassetsDir: web\n"
+	if err := os.WriteFile(path, []byte(yml), 0600); nil != err {
+		t.Fatalf("could not write configuration %v", err)
+	}
+	t.Setenv("REMOTE_MOVE_SERVER_BIND_PORT", "9100")
+	t.Setenv("REMOTE_MOVE_SRC_DIRS", "/b, /c")
+
+	if err := LoadConfiguration(path, nil); nil != err {
+		t.Fatalf("failed to load configuration %v", err)
+	}
+	if "9100" != Confs.ServerBindPort {
+		t.Fatalf("env should override the file, got port %s", Confs.ServerBindPort)
+	}
+	if "10.0.0.1" != Confs.ServerBindAddr {
+		t.Fatalf("file should override the defaults, got addr %s", Confs.ServerBindAddr)
+	}
+	if 2 != len(Confs.SrcDirs) || "/b" != Confs.SrcDirs[0] || "/c" != Confs.SrcDirs[1] {
+		t.Fatalf("srcDirs not overridden by env %v", Confs.SrcDirs)
+	}
+	if filepath.Join(dir, "web") != Confs.AssetsDir {
+		t.Fatalf("assets dir should be relative to the configuration file, got %s", Confs.AssetsDir)
+	}
+	if 1 != len(Confs.DestRoots) || "media" != Confs.DestRoots[0].Name {
+		t.Fatalf("destRootDir should become a root named media %v", Confs.DestRoots)
+	}
+	if 1 != Confs.Uid || 2 != Confs.Gid {
+		t.Fatalf("uid gid not resolved %d %d", Confs.Uid, Confs.Gid)
+	}
+
+	// the flags override an owner of the file that can't be resolved
+	yml = strings.Replace(yml, "chownUsrGrp: 1:2", "chownUsrGrp: nosuchuser:nosuchgroup", 1)
+	if err := os.WriteFile(path, []byte(yml), 0600); nil != err {
+		t.Fatalf("could not write configuration %v", err)
+	}
+	if err := LoadConfiguration(path, nil); nil == err || 1 != Confs.Uid {
+		t.Fatalf("an unknown owner should be refused, keeping the previous configuration")
+	}
+	err := LoadConfiguration(path, func(c *Configuration) { c.ChownUsrGrp = "3:4" })
+	if nil != err || 3 != Confs.Uid || 4 != Confs.Gid {
+		t.Fatalf("the flags should override the file before it is resolved %v %d %d", err, Confs.Uid, Confs.Gid)
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	dir := t.TempDir()
+	PasswdFile = filepath.Join(dir, "passwd")
+	GroupFile = filepath.Join(dir, "group")
+	defer func() { PasswdFile, GroupFile = "/etc/passwd", "/etc/group" }()
+	os.WriteFile(PasswdFile, []byte("root:x:0:0:root:/root:/bin/sh\njellyfin:x:968:970::/var/lib/jellyfin:/usr/bin/nologin\n"), 0600)
+	os.WriteFile(GroupFile, []byte("root:x:0:\nmedia:x:1001:jellyfin\n"), 0600)
+
+	expect := map[string][2]int{
+		"968:970":        {968, 970},
+		"jellyfin:media": {968, 1001},
+		"jellyfin":       {968, 970},
+		"0:media":        {0, 1001},
+	}
+	for owner, ids := range expect {
+		uid, gid, err := ParseOwner(owner)
+		if nil != err || ids[0] != uid || ids[1] != gid {
+			t.Fatalf("%s should resolve to %v, got %d:%d %v", owner, ids, uid, gid, err)
+		}
+	}
+	for _, owner := range []string{"nobody:media", "jellyfin:nogroup", "968", ":media", "jellyfin:"} {
+		if _, _, err := ParseOwner(owner); nil == err {
+			t.Fatalf("%s should not resolve", owner)
+		}
+	}
+}
+
+func TestValidateAccess(t *testing.T) {
+	roles := []Role{{Name: "media", Sources: []string{"/srv/*"}, Destinations: []string{"media"}, Operations: []string{"move"}}}
+	invalid := map[string]Configuration{
+		"duplicate role": {Roles: append(roles, roles[0])},
+		"unknown op":     {Roles: []Role{{Name: "x", Operations: []string{"rm"}}}},
+		"bad pattern":    {Roles: []Role{{Name: "x", Sources: []string{"[a"}}}},
+		"unknown role":   {Roles: roles, Users: []User{{Name: "alice", Password: "x", Roles: []string{"admin"}}}},
+		"no password":    {Roles: roles, Users: []User{{Name: "alice", Roles: []string{"media"}}}},
+		"anonymous role": {Roles: roles, AnonymousRoles: []string{"admin"}},
+		"duplicate user": {Roles: roles, Users: []User{{Name: "a", Password: "x"}, {Name: "a", Password: "x"}}},
+	}
+	for name, c := range invalid {
+		if err := c.validateAccess(); nil == err {
+			t.Fatalf("%s should be refused", name)
+		}
+	}
+	c := Configuration{Roles: roles, Users: []User{{Name: "alice", Password: "x", Roles: []string{"media"}}}, AnonymousRoles: []string{"media"}}
+	if err := c.validateAccess(); nil != err {
+		t.Fatalf("valid roles refused %v", err)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	roles := []Role{{Name: "media"}}
+	users := []User{{Name: "alice", Password: "x"}}
+	invalid := map[string]TLS{
+		"cert without key":   {Cert: "c.pem"},
+		"ca without cert":    {ClientCA: "ca.pem"},
+		"require without ca": {Cert: "c.pem", Key: "c.key", RequireClientCert: true},
+		"user and roles":     {Cert: "c.pem", Key: "c.key", ClientCA: "ca.pem", ClientCerts: []ClientCert{{Subject: "phone", User: "alice", Roles: []string{"media"}}}},
+		"unknown user":       {Cert: "c.pem", Key: "c.key", ClientCA: "ca.pem", ClientCerts: []ClientCert{{Subject: "phone", User: "bob"}}},
+		"unknown role":       {Cert: "c.pem", Key: "c.key", ClientCA: "ca.pem", ClientCerts: []ClientCert{{Subject: "phone", Roles: []string{"admin"}}}},
+	}
+	for name, tls := range invalid {
+		c := Configuration{Roles: roles, Users: users, TLS: tls}
+		if err := c.validateAccess(); nil == err {
+			t.Fatalf("%s should be refused", name)
+		}
+	}
+	c := Configuration{Roles: roles, Users: users, TLS: TLS{Cert: "c.pem", Key: "c.key", ClientCA: "ca.pem", ClientCerts: []ClientCert{{Subject: "phone", User: "alice"}, {Subject: "CN=tablet", Roles: []string{"media"}}}}}
+	if err := c.validateAccess(); nil != err {
+		t.Fatalf("valid tls refused %v", err)
+	}
+}
+
+func TestValidateForwardAuth(t *testing.T) {
+	roles := []Role{{Name: "media"}}
+	invalid := map[string]Configuration{
+		"no proxies":   {ForwardAuth: ForwardAuth{Enabled: true, UserHeader: "Remote-User"}},
+		"no header":    {TrustedProxies: []string{"127.0.0.1"}, ForwardAuth: ForwardAuth{Enabled: true}},
+		"unknown role": {ForwardAuth: ForwardAuth{Enabled: true, Proxies: []string{"127.0.0.1"}, UserHeader: "Remote-User", Groups: []GroupRoles{{Group: "admins", Roles: []string{"admin"}}}}},
+	}
+	for name, c := range invalid {
+		c.Roles = roles
+		if err := c.validateAccess(); nil == err {
+			t.Fatalf("%s should be refused", name)
+		}
+	}
+	c := Configuration{Roles: roles, TrustedProxies: []string{"127.0.0.1"}, ForwardAuth: ForwardAuth{Enabled: true, UserHeader: "Remote-User", Groups: []GroupRoles{{Group: "family", Roles: []string{"media"}}}}}
+	if err := c.validateAccess(); nil != err {
+		t.Fatalf("valid forward auth refused %v", err)
+	}
+}
+
+func TestResolveWebhooks(t *testing.T) {
+	invalid := map[string]Webhook{
+		"no name":       {URL: "https://example.com/hook"},
+		"no url":        {Name: "hook"},
+		"ftp url":       {Name: "hook", URL: "ftp://example.com/hook"},
+		"unknown event": {Name: "hook", URL: "https://example.com/hook", Events: []string{"move.done"}},
+		"bad pattern":   {Name: "hook", URL: "https://example.com/hook", Events: []string{"[move"}},
+		"negative":      {Name: "hook", URL: "https://example.com/hook", Attempts: -1},
+	}
+	for name, w := range invalid {
+		c := Configuration{Webhooks: []Webhook{w}}
+		if err := c.resolveWebhooks(); nil == err {
+			t.Fatalf("%s should be refused", name)
+		}
+	}
+	c := Configuration{Webhooks: []Webhook{{Name: "hook", URL: "http://127.0.0.1:8000/hook", Events: []string{"move.*", "*.failed"}}}}
+	if err := c.resolveWebhooks(); nil != err {
+		t.Fatalf("valid webhook refused %v", err)
+	}
+	if w := c.Webhooks[0]; 4 != w.Attempts || 10*time.Second != w.Timeout || time.Second != w.Backoff || "X-Remote-Move-Signature" != w.SignatureHeader {
+		t.Fatalf("the defaults should be filled in, got %+v", w)
+	}
+	c.Webhooks = append(c.Webhooks, c.Webhooks[0])
+	if err := c.resolveWebhooks(); nil == err {
+		t.Fatalf("duplicate webhook names should be refused")
+	}
+}
@@ -0,0 +1,108 @@
+package conf
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the account databases names are resolved against, parsed directly so that
+// no cgo (nss) is needed.
+var (
+	PasswdFile = "/etc/passwd"
+	GroupFile  = "/etc/group"
+)
+
+// lookupEntry returns the fields of the line of the colon separated file
+// whose first field is name.
+func lookupEntry(file, name string) ([]string, error) {
+	fh, err := os.Open(file)
+	if nil != err {
+		return nil, err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) > 3 && fields[0] == name {
+			return fields, nil
+		}
+	}
+	if err = scanner.Err(); nil != err {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// LookupUser returns the uid and primary gid of the user, which can be a
+// name or a numeric uid.
+func LookupUser(usr string) (int, int, error) {
+	if uid, err := strconv.Atoi(usr); nil == err {
+		return uid, -1, nil
+	}
+	fields, err := lookupEntry(PasswdFile, usr)
+	if nil != err {
+		return -1, -1, errors.New("failed to read " + PasswdFile + ": " + err.Error())
+	}
+	if nil == fields {
+		return -1, -1, errors.New("user " + usr + " does not exist in " + PasswdFile)
+	}
+	uid, err := strconv.Atoi(fields[2])
+	if nil != err {
+		return -1, -1, errors.New("invalid uid for user " + usr + " in " + PasswdFile)
+	}
+	gid, err := strconv.Atoi(fields[3])
+	if nil != err {
+		return -1, -1, errors.New("invalid gid for user " + usr + " in " + PasswdFile)
+	}
+	return uid, gid, nil
+}
+
+// LookupGroup returns the gid of the group, which can be a name or a numeric
+// gid.
+func LookupGroup(grp string) (int, error) {
+	if gid, err := strconv.Atoi(grp); nil == err {
+		return gid, nil
+	}
+	fields, err := lookupEntry(GroupFile, grp)
+	if nil != err {
+		return -1, errors.New("failed to read " + GroupFile + ": " + err.Error())
+	}
+	if nil == fields {
+		return -1, errors.New("group " + grp + " does not exist in " + GroupFile)
+	}
+	gid, err := strconv.Atoi(fields[2])
+	if nil != err {
+		return -1, errors.New("invalid gid for group " + grp + " in " + GroupFile)
+	}
+	return gid, nil
+}
+
+// ParseOwner resolves owner, of the form usr:grp where both can be names or
+// numeric ids (jellyfin:media, 127:127). A lone user name takes its primary
+// group.
+func ParseOwner(owner string) (int, int, error) {
+	usr, grp, hasGrp := strings.Cut(owner, ":")
+	if "" == usr || (hasGrp && "" == grp) {
+		return -1, -1, errors.New("should be of format usr:grp, names or ids (jellyfin:media, 127:127), provided : " + owner)
+	}
+	uid, gid, err := LookupUser(usr)
+	if nil != err {
+		return -1, -1, err
+	}
+	if hasGrp {
+		if gid, err = LookupGroup(grp); nil != err {
+			return -1, -1, err
+		}
+	} else if -1 == gid {
+		return -1, -1, errors.New("a numeric uid needs a group, provided : " + owner)
+	}
+	return uid, gid, nil
+}
@@ -1,5 +1,5 @@
 module github.com/shoaib42/remote-move
 
-go 1.20
+go 1.21
 
 require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,99 @@
+package io
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// The kinds of errors of the operations, compare with errors.Is, ex:
+// errors.Is(err, io.ErrNotFound).
+var (
+	ErrInvalid       = errors.New("invalid request")
+	ErrInvalidName   = errors.New("invalid name")
+	ErrNotFound      = errors.New("not found")
+	ErrNotAccessible = errors.New("not accessible")
+	ErrConflict      = errors.New("already exists")
+	ErrCrossDevice   = errors.New("cross device")
+	ErrPermission    = errors.New("permission denied")
+	ErrNoSpace       = errors.New("no space left")
+)
+
+// OpError is a failed move or copy, Kind is one of the Err* kinds and Err
+// the underlying error if any, ex: the syscall.Errno of a failed rename.
+type OpError struct {
+	Kind error
+	Msg  string
+	Path string
+	Err  error
+}
+
+func (e *OpError) Error() string {
+	msg := e.Msg
+	if "" != e.Path {
+		msg += ": " + e.Path
+	}
+	if nil != e.Err {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OpError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func opError(kind error, msg string) error {
+	return &OpError{Kind: kind, Msg: msg}
+}
+
+// validName reports if name is a single path element
+func validName(name string) bool {
+	return "" != name && "." != name && ".." != name && !strings.ContainsAny(name, "/\x00")
+}
+
+// kindOf classifies an error returned by the file system
+func kindOf(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOENT), errors.Is(err, syscall.ENOTDIR):
+		return ErrNotFound
+	case errors.Is(err, syscall.EEXIST), errors.Is(err, syscall.ENOTEMPTY):
+		return ErrConflict
+	case errors.Is(err, syscall.EXDEV):
+		return ErrCrossDevice
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EROFS):
+		return ErrPermission
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return ErrNoSpace
+	case errors.Is(err, syscall.ENAMETOOLONG):
+		return ErrInvalidName
+	case errors.Is(err, syscall.EINVAL):
+		// ex: moving a directory into itself, the name is fine
+		return ErrInvalid
+	}
+	return nil
+}
+
+// fsError wraps the syscall error of err, from the file system, in an
+// OpError of its kind, msg says what failed, ex: "could not move".
+func fsError(msg, path string, err error) error {
+	kind := kindOf(err)
+	if nil == kind {
+		return err
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		err = errno
+	}
+	switch kind {
+	case ErrCrossDevice:
+		msg = "source and destination are on different file systems, copy instead"
+	case ErrNoSpace:
+		msg = "no space left on destination"
+	}
+	return &OpError{Kind: kind, Msg: msg, Path: path, Err: err}
+}
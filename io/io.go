@@ -3,33 +3,47 @@ package io
 import (
 	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/shoaib42/remote-move/conf"
+	"github.com/shoaib42/remote-move/metrics"
+)
+
+var (
+	opsTotal    = metrics.Default.NewCounterVec("remote_move_operations_total", "Move/copy operations by result (success, error, policy_error).", "operation", "result")
+	opDuration  = metrics.Default.NewHistogramVec("remote_move_operation_duration_seconds", "Duration of move/copy operations, including the chown.", metrics.DefBuckets, "operation")
+	copiedBytes = metrics.Default.NewCounterVec("remote_move_copied_bytes_total", "Bytes of regular files copied.")
 )
 
 type IOHelpers interface {
 	DoMvChown(from, what, where string) error
 	DoCpChown(from, what, where string) error
-	GetDestDirList() ([]string, error)
+	GetDestDirList() (map[string][]string, error)
 	GetSrcMapItems() (map[string][]string, error)
+	GetDestFreeSpace() (map[string]uint64, error)
+	GetDestPolicy(where string) conf.Policy
+	SetPolicies(defaults conf.Policy, policies []conf.Policy)
 }
 
 type IoConf struct {
 	mu           sync.Mutex
-	destRootDir  string
+	destRoots    map[string]string
 	srcDirs      []string
 	excludeDirs  map[string]conf.VoidT
 	srcDirsItems map[string][]string
-	uid          int
-	gid          int
+	pmu          sync.RWMutex
+	defaults     conf.Policy
+	policies     []conf.Policy
 }
 
-func NewIOHelper(srcDirs []string, destDirRoot string, excludeDirs []string, uid, gid int) (IOHelpers, error) {
+func NewIOHelper(srcDirs []string, destRoots []conf.DestRoot, excludeDirs []string, defaults conf.Policy, policies []conf.Policy) (IOHelpers, error) {
 
 	srcDirsSorted := make([]string, len(srcDirs))
 	copy(srcDirsSorted, srcDirs)
@@ -40,13 +54,21 @@ func NewIOHelper(srcDirs []string, destDirRoot string, excludeDirs []string, uid
 		excldDirs[e] = conf.Void
 	}
 
+	roots := make(map[string]string, len(destRoots))
+	for _, r := range destRoots {
+		if _, dup := roots[r.Name]; dup {
+			return nil, errors.New("duplicate destination root " + r.Name)
+		}
+		roots[r.Name] = r.Path
+	}
+
 	return &IoConf{
-		destRootDir:  destDirRoot,
+		destRoots:    roots,
 		srcDirs:      srcDirsSorted,
 		excludeDirs:  excldDirs,
 		srcDirsItems: nil,
-		uid:          uid,
-		gid:          gid,
+		defaults:     defaults,
+		policies:     policies,
 	}, nil
 }
 
@@ -58,32 +80,45 @@ func find(list []string, what string) bool {
 }
 
 func checkIfExists(path string) bool {
-	_, err := os.Stat(path)
-	return os.IsExist(err)
+	_, err := os.Lstat(path)
+	return nil == err
 }
 
-func (i *IoConf) doChown(dest string) error {
-	return filepath.Walk(dest, func(name string, info os.FileInfo, err error) error {
-		if nil == err {
-			err = os.Chown(name, i.uid, i.gid)
-		}
-		return err
-	})
+func (i *IoConf) doChown(where, dest string) (int64, error) {
+	_, _, destDir := i.destPath(where)
+	p, err := effective(i.GetDestPolicy(where), destDir)
+	if nil != err {
+		return 0, err
+	}
+	return applyPolicy(p, dest)
+}
+
+// destPath resolves where, of the form root/subdir, to the destination
+// directory on disk.
+func (i *IoConf) destPath(where string) (root, subdir, path string) {
+	root, subdir, _ = strings.Cut(where, "/")
+	if base, ok := i.destRoots[root]; ok && "" != subdir {
+		path = base + "/" + subdir
+	}
+	return
 }
 
 func (i *IoConf) checkCopyOrMoveValid(from, what, where string) error {
-	dest := i.destRootDir + "/" + where
+	root, subdir, dest := i.destPath(where)
 	if from == "" {
-		return errors.New("source directory was not provided")
+		return opError(ErrInvalid, "source directory was not provided")
 	}
 	if what == "" {
-		return errors.New("file/dir to move|copy was not provided")
+		return opError(ErrInvalid, "file/dir to move|copy was not provided")
 	}
 	if where == "" {
-		return errors.New("destination directory was not provided")
+		return opError(ErrInvalid, "destination directory was not provided")
+	}
+	if !validName(what) {
+		return opError(ErrInvalidName, "invalid item name "+what)
 	}
 	if from == dest {
-		return errors.New("src and dest directories cannot be the same")
+		return opError(ErrInvalid, "src and dest directories cannot be the same")
 	}
 	srcMapList, err := i.GetSrcMapItems()
 	if nil != err {
@@ -92,20 +127,48 @@ func (i *IoConf) checkCopyOrMoveValid(from, what, where string) error {
 
 	items, ok := srcMapList[from]
 	if !ok {
-		return errors.New("source directory not accessible")
+		return opError(ErrNotAccessible, "source directory not accessible")
 	}
 
 	if !find(items, what) {
-		return errors.New("item not found in source directory")
+		return opError(ErrNotFound, "item not found in source directory")
+	}
+
+	if _, ok := i.destRoots[root]; !ok {
+		return opError(ErrNotFound, "destination root not found")
+	}
+
+	destDirs, err := i.listDestRoot(root)
+	if nil != err || !find(destDirs, subdir) {
+		return opError(ErrNotAccessible, "destination directory not accessible")
 	}
 
-	destDirs, err := i.GetDestDirList()
-	if !find(destDirs, where) {
-		return errors.New("destination directory not accessible")
+	if checkIfExists(dest + "/" + what) {
+		return opError(ErrConflict, "item already exists in destination directory")
 	}
 	return nil
 }
 
+// observe records the metrics and logs the outcome of an operation
+func observe(op, src, dest string, start time.Time, size int64, err error) {
+	var pe *PolicyError
+	result := "success"
+	if errors.As(err, &pe) {
+		result = "policy_error"
+	} else if nil != err {
+		result = "error"
+	}
+	opsTotal.Inc(op, result)
+	opDuration.Since(start, op)
+
+	attrs := []any{"operation", op, "src", src, "dest", dest, "bytes", size, "duration", time.Since(start)}
+	if nil != err {
+		slog.Warn("operation failed", append(attrs, "result", result, "error", err)...)
+	} else {
+		slog.Info("operation done", attrs...)
+	}
+}
+
 // Start https://stackoverflow.com/questions/51779243/copy-a-folder-in-go
 func copyDir(src, dest string) error {
 
@@ -130,7 +193,7 @@ func copyDir(src, dest string) error {
 		// copy contents of regular file efficiently
 
 		// open input
-		in, _ := os.Open(path)
+		in, err := os.Open(path)
 		if err != nil {
 			return err
 		}
@@ -147,54 +210,61 @@ func copyDir(src, dest string) error {
 		fh.Chmod(info.Mode())
 
 		// copy content
-		_, err = io.Copy(fh, in)
+		n, err := io.Copy(fh, in)
+		copiedBytes.Add(float64(n))
 		return err
 	})
 }
 
 // End https://stackoverflow.com/questions/51779243/copy-a-folder-in-go
 
-func (i *IoConf) doCpChown(from, what, where string) error {
+func (i *IoConf) doCpChown(from, what, where string) (int64, error) {
 	if err := i.checkCopyOrMoveValid(from, what, where); err != nil {
-		return err
+		return 0, err
 	}
 
 	src := from + "/" + what
-	dest := i.destRootDir + "/" + where + "/" + what
+	_, _, destDir := i.destPath(where)
+	dest := destDir + "/" + what
 
 	if err := copyDir(src, dest); nil != err {
-		return err
+		return 0, fsError("could not copy", dest, err)
 	}
 
-	return i.doChown(dest)
+	return i.doChown(where, dest)
 }
 
 func (i *IoConf) DoCpChown(from, what, where string) error {
+	start := time.Now()
 	i.mu.Lock()
-	err := i.doCpChown(from, what, where)
+	size, err := i.doCpChown(from, what, where)
 	i.mu.Unlock()
+	observe("copy", from+"/"+what, where, start, size, err)
 	return err
 }
 
-func (i *IoConf) doMvChown(from, what, where string) error {
+func (i *IoConf) doMvChown(from, what, where string) (int64, error) {
 	if err := i.checkCopyOrMoveValid(from, what, where); err != nil {
-		return err
+		return 0, err
 	}
 
 	src := from + "/" + what
-	dest := i.destRootDir + "/" + where + "/" + what
+	_, _, destDir := i.destPath(where)
+	dest := destDir + "/" + what
 
 	if err := os.Rename(src, dest); nil != err {
-		return err
+		return 0, fsError("could not move", src, err)
 	}
 
-	return i.doChown(dest)
+	return i.doChown(where, dest)
 }
 
 func (i *IoConf) DoMvChown(from, what, where string) error {
+	start := time.Now()
 	i.mu.Lock()
-	err := i.doMvChown(from, what, where)
+	size, err := i.doMvChown(from, what, where)
 	i.mu.Unlock()
+	observe("move", from+"/"+what, where, start, size, err)
 	return err
 }
 
@@ -222,9 +292,21 @@ func (i *IoConf) GetSrcMapItems() (map[string][]string, error) {
 	return ret, err
 }
 
-func (i *IoConf) GetDestDirList() ([]string, error) {
+func (i *IoConf) GetDestDirList() (map[string][]string, error) {
+	ret := make(map[string][]string, len(i.destRoots))
+	for root := range i.destRoots {
+		list, err := i.listDestRoot(root)
+		if nil != err {
+			return ret, err
+		}
+		ret[root] = list
+	}
+	return ret, nil
+}
+
+func (i *IoConf) listDestRoot(root string) ([]string, error) {
 	ret := make([]string, 0)
-	entries, err := os.ReadDir(i.destRootDir)
+	entries, err := os.ReadDir(i.destRoots[root])
 	if nil == err {
 		for _, e := range entries {
 			if e.IsDir() {
@@ -237,3 +319,17 @@ func (i *IoConf) GetDestDirList() ([]string, error) {
 	sort.Strings(ret)
 	return ret, err
 }
+
+// GetDestFreeSpace returns the bytes available to unprivileged users on the
+// filesystem of each destination root.
+func (i *IoConf) GetDestFreeSpace() (map[string]uint64, error) {
+	ret := make(map[string]uint64, len(i.destRoots))
+	for root, path := range i.destRoots {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(path, &st); nil != err {
+			return ret, err
+		}
+		ret[root] = uint64(st.Bavail) * uint64(st.Bsize)
+	}
+	return ret, nil
+}
@@ -1,10 +1,13 @@
 package io
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/shoaib42/remote-move/conf"
@@ -14,6 +17,8 @@ var testRootDir = "test"
 var srcDirs = []string{testRootDir + "/src1", testRootDir + "/src2", testRootDir + "/src3"}
 var destRootDir = testRootDir + "/dest"
 var destDirs = []string{destRootDir + "/land1", destRootDir + "/land2", destRootDir + "/land3"}
+var archiveRootDir = testRootDir + "/archive"
+var archiveDirs = []string{archiveRootDir + "/old1"}
 var dirWantExclude = []string{"exclude1", "exclude2"}
 var filesCreate = []string{"file1", "file2"}
 var dirsCreate = []string{"dir1", "dir2"}
@@ -40,13 +45,16 @@ func mock_data() *conf.Configuration {
 		}
 	}
 
-	for _, p := range destDirs {
+	for _, p := range append(destDirs, archiveDirs...) {
 		os.MkdirAll(p, os.ModePerm)
 	}
 
 	return &conf.Configuration{
-		SrcDirs:     srcDirs,
-		DestRootDir: destRootDir,
+		SrcDirs: srcDirs,
+		DestRoots: []conf.DestRoot{
+			{Name: "dest", Path: destRootDir},
+			{Name: "archive", Path: archiveRootDir},
+		},
 		ExcludeDirs: dirWantExclude,
 		Uid:         os.Geteuid(),
 		Gid:         os.Getegid(),
@@ -65,7 +73,7 @@ func TestGetSrcs(t *testing.T) {
 func TestCreateIOHelper(t *testing.T) {
 	conf := mock_data()
 	defer tearDown()
-	_, err := NewIOHelper(conf.SrcDirs, conf.DestRootDir, conf.ExcludeDirs, conf.Uid, conf.Gid)
+	_, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
 	if nil != err {
 		t.Fatalf("Could not create io helper")
 	}
@@ -74,15 +82,22 @@ func TestCreateIOHelper(t *testing.T) {
 func TestGetDestDir(t *testing.T) {
 	conf := mock_data()
 	defer tearDown()
-	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRootDir, conf.ExcludeDirs, conf.Uid, conf.Gid)
+	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
 	if nil != err {
 		t.Fatalf("Could not create io helper")
 	}
-	dest, err := ioh.GetDestDirList()
+	destMap, err := ioh.GetDestDirList()
 
 	if nil != err {
 		t.Fatalf("Could not get dest directories")
 	}
+	if len(destMap) != len(conf.DestRoots) {
+		t.Fatalf("the number of destination roots is incorrect %v", destMap)
+	}
+	if len(destMap["archive"]) != len(archiveDirs) {
+		t.Fatalf("the number of archive dirs is incorrect %v", destMap["archive"])
+	}
+	dest := destMap["dest"]
 	if len(destDirs) != len(dest) {
 		t.Fatalf("the number of destination dirs is incorrect")
 	}
@@ -109,7 +124,7 @@ func TestGetDestDir(t *testing.T) {
 func TestGetSourceItems(t *testing.T) {
 	conf := mock_data()
 	defer tearDown()
-	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRootDir, conf.ExcludeDirs, conf.Uid, conf.Gid)
+	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
 	if nil != err {
 		t.Fatalf("Could not create io helper")
 	}
@@ -158,7 +173,7 @@ func TestGetSourceItems(t *testing.T) {
 func TestMove(t *testing.T) {
 	conf := mock_data()
 	defer tearDown()
-	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRootDir, conf.ExcludeDirs, conf.Uid, conf.Gid)
+	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
 	if nil != err {
 		t.Fatalf("Could not create io helper")
 	}
@@ -168,7 +183,7 @@ func TestMove(t *testing.T) {
 	//add a file to move
 	os.Create(fileSrcFullPath)
 
-	dest := strings.Replace(destDirs[0], destRootDir+"/", "", 1)
+	dest := strings.Replace(destDirs[0], destRootDir+"/", "dest/", 1)
 	err = ioh.DoMvChown(srcDirs[0], filetomove, dest)
 	if nil != err {
 		t.Fatalf("Failed to move with error %v", err)
@@ -202,7 +217,7 @@ func TestMove(t *testing.T) {
 func TestCopy(t *testing.T) {
 	conf := mock_data()
 	defer tearDown()
-	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRootDir, conf.ExcludeDirs, conf.Uid, conf.Gid)
+	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
 	if nil != err {
 		t.Fatalf("Could not create io helper")
 	}
@@ -212,7 +227,7 @@ func TestCopy(t *testing.T) {
 	//add a file to copy
 	os.Create(fileSrcFullPath + "/" + "extraFile")
 
-	dest := strings.Replace(destDirs[0], destRootDir+"/", "", 1)
+	dest := strings.Replace(destDirs[0], destRootDir+"/", "dest/", 1)
 	err = ioh.DoCpChown(srcDirs[0], dirsCreate[0], dest)
 	if nil != err {
 		t.Fatalf("Failed to move with error %v", err)
@@ -266,3 +281,178 @@ func TestCopy(t *testing.T) {
 	}
 
 }
+
+func TestMoveToSecondRoot(t *testing.T) {
+	conf := mock_data()
+	defer tearDown()
+	ioh, err := NewIOHelper(conf.SrcDirs, conf.DestRoots, conf.ExcludeDirs, conf.DefaultPolicy(), conf.Policies)
+	if nil != err {
+		t.Fatalf("Could not create io helper")
+	}
+
+	err = ioh.DoMvChown(srcDirs[0], filesCreate[0], "archive/old1")
+	if nil != err {
+		t.Fatalf("Failed to move with error %v", err)
+	}
+	if _, err = os.Stat(archiveDirs[0] + "/" + filesCreate[0]); nil != err {
+		t.Fatalf("File not found in archive root %v", err)
+	}
+
+	// land1 exists under dest but not under archive
+	err = ioh.DoMvChown(srcDirs[0], filesCreate[1], "archive/land1")
+	if nil == err {
+		t.Fatalf("move to a subdir of another root should fail")
+	}
+	err = ioh.DoMvChown(srcDirs[0], filesCreate[1], "nosuchroot/land1")
+	if nil == err {
+		t.Fatalf("move to an unknown root should fail")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	c := mock_data()
+	defer tearDown()
+	c.Policies = []conf.Policy{
+		{Match: "dest/land2", FileMode: "0640", DirMode: "0750"},
+		{Match: "archive", Owner: conf.OwnerInherit, FileMode: conf.ModeInherit},
+	}
+	for n := range c.Policies {
+		if err := c.Policies[n].Resolve(); nil != err {
+			t.Fatalf("Could not resolve policy %v", err)
+		}
+	}
+	ioh, err := NewIOHelper(c.SrcDirs, c.DestRoots, c.ExcludeDirs, c.DefaultPolicy(), c.Policies)
+	if nil != err {
+		t.Fatalf("Could not create io helper")
+	}
+
+	if p := ioh.GetDestPolicy("dest/land1"); "" != p.Match || c.Uid != p.Uid || 0 != p.FilePerm {
+		t.Fatalf("land1 should get the default policy %v", p)
+	}
+	if p := ioh.GetDestPolicy("archive/old1"); "archive" != p.Match || !p.InheritOwner {
+		t.Fatalf("archive/old1 should get the archive policy %v", p)
+	}
+	setgid, noSetgid := true, false
+	ioh.SetPolicies(conf.Policy{Uid: c.Uid, Gid: c.Gid, SetgidDirs: &setgid}, []conf.Policy{
+		{Match: "dest/land2", Uid: -1, Gid: -1, SetgidDirs: &noSetgid},
+		{Match: "archive", Uid: -1, Gid: -1},
+	})
+	if p := ioh.GetDestPolicy("dest/land2"); p.Setgid() {
+		t.Fatalf("a policy should turn setgidDirs off %v", p)
+	}
+	if p := ioh.GetDestPolicy("archive/old1"); !p.Setgid() {
+		t.Fatalf("a policy without setgidDirs should take the global one %v", p)
+	}
+	ioh.SetPolicies(c.DefaultPolicy(), c.Policies)
+
+	err = ioh.DoCpChown(srcDirs[0], dirsCreate[0], "dest/land2")
+	if nil != err {
+		t.Fatalf("Failed to copy with error %v", err)
+	}
+	dir := destDirs[1] + "/" + dirsCreate[0]
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		expect := os.FileMode(0640)
+		if info.IsDir() {
+			expect = 0750
+		}
+		if expect != info.Mode().Perm() {
+			t.Fatalf("%s should have mode %o, got %o", name, expect, info.Mode().Perm())
+		}
+		return nil
+	})
+	if nil != err {
+		t.Fatalf("Failed walking copied dir %v", err)
+	}
+
+	os.Chmod(archiveDirs[0], 0755)
+	err = ioh.DoMvChown(srcDirs[0], filesCreate[0], "archive/old1")
+	if nil != err {
+		t.Fatalf("Failed to move with error %v", err)
+	}
+	info, err := os.Stat(archiveDirs[0] + "/" + filesCreate[0])
+	if nil != err || 0644 != info.Mode().Perm() {
+		t.Fatalf("moved file should inherit 0644 from its parent %v %v", info, err)
+	}
+}
+
+func TestUmaskSetgid(t *testing.T) {
+	c := mock_data()
+	defer tearDown()
+	c.ChownUsrGrp = strconv.Itoa(c.Uid) + ":" + strconv.Itoa(c.Gid)
+	c.Umask = "0027"
+	c.SetgidDirs = true
+	if err := c.Resolve(); nil != err {
+		t.Fatalf("Could not resolve configuration %v", err)
+	}
+	ioh, err := NewIOHelper(c.SrcDirs, c.DestRoots, c.ExcludeDirs, c.DefaultPolicy(), c.Policies)
+	if nil != err {
+		t.Fatalf("Could not create io helper")
+	}
+
+	os.Chmod(srcDirs[0]+"/"+dirsCreate[0]+"/sub"+dirsCreate[0]+"/"+filesCreate[0], 0777)
+	err = ioh.DoMvChown(srcDirs[0], dirsCreate[0], "dest/land1")
+	if nil != err {
+		t.Fatalf("Failed to move with error %v", err)
+	}
+	err = filepath.Walk(destDirs[0]+"/"+dirsCreate[0], func(name string, info os.FileInfo, err error) error {
+		if nil != err {
+			return err
+		}
+		expect := os.FileMode(0640)
+		if info.IsDir() {
+			expect = 0750 | os.ModeSetgid
+		}
+		if expect != info.Mode()&(os.ModePerm|os.ModeSetgid) {
+			t.Fatalf("%s should have mode %v, got %v", name, expect, info.Mode())
+		}
+		return nil
+	})
+	if nil != err {
+		t.Fatalf("Failed walking moved dir %v", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	c := mock_data()
+	defer tearDown()
+	ioh, err := NewIOHelper(c.SrcDirs, c.DestRoots, c.ExcludeDirs, c.DefaultPolicy(), c.Policies)
+	if nil != err {
+		t.Fatalf("Could not create io helper")
+	}
+
+	cases := []struct {
+		from, what, where string
+		kind              error
+	}{
+		{srcDirs[0], "missing", "dest/land1", ErrNotFound},
+		{srcDirs[0], "..", "dest/land1", ErrInvalidName},
+		{srcDirs[0], "", "dest/land1", ErrInvalid},
+		{testRootDir + "/nope", filesCreate[0], "dest/land1", ErrNotAccessible},
+		{srcDirs[0], filesCreate[0], "nope/land1", ErrNotFound},
+		{srcDirs[0], filesCreate[0], "dest/nope", ErrNotAccessible},
+	}
+	for _, tc := range cases {
+		err = ioh.DoMvChown(tc.from, tc.what, tc.where)
+		if !errors.Is(err, tc.kind) {
+			t.Fatalf("moving %s/%s to %s should fail with %v, got %v", tc.from, tc.what, tc.where, tc.kind, err)
+		}
+	}
+
+	os.Create(destDirs[0] + "/" + filesCreate[0])
+	err = ioh.DoCpChown(srcDirs[0], filesCreate[0], "dest/land1")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("copying over an existing item should conflict, got %v", err)
+	}
+
+	err = fsError("could not move", "a", &os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EXDEV})
+	if !errors.Is(err, ErrCrossDevice) || !errors.Is(err, syscall.EXDEV) {
+		t.Fatalf("a cross device rename should be ErrCrossDevice wrapping EXDEV, got %v", err)
+	}
+	err = fsError("could not move", "a", &os.LinkError{Op: "rename", Old: "a", New: "a/b", Err: syscall.EINVAL})
+	if !errors.Is(err, ErrInvalid) || errors.Is(err, ErrInvalidName) {
+		t.Fatalf("renaming a directory into itself should be ErrInvalid, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package io
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// PathFailure is a path the policy could not be applied to, Op is chown,
+// lchown or chmod.
+type PathFailure struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+// PolicyError is returned when the move or copy succeeded but the ownership
+// or permissions could not be applied to some of the items.
+type PolicyError struct {
+	Failures []PathFailure
+}
+
+func (e *PolicyError) Error() string {
+	msg := "failed to apply ownership/permissions to " + e.Failures[0].Path + ": " + e.Failures[0].Err.Error()
+	if len(e.Failures) > 1 {
+		msg += " (and " + strconv.Itoa(len(e.Failures)-1) + " more)"
+	}
+	return msg
+}
+
+// matches reports if the policy applies to where (root/subdir), a match
+// without a / applies to every subdir of the root with that name.
+func matches(p conf.Policy, where string) bool {
+	if !strings.Contains(p.Match, "/") {
+		root, _, _ := strings.Cut(where, "/")
+		ok, _ := path.Match(p.Match, root)
+		return ok
+	}
+	ok, _ := path.Match(p.Match, where)
+	return ok
+}
+
+// GetDestPolicy returns the policy applied to items landing in where, the
+// first matching policy completed by the default policy, with an empty Match
+// when no policy matched.
+func (i *IoConf) GetDestPolicy(where string) conf.Policy {
+	i.pmu.RLock()
+	defer i.pmu.RUnlock()
+	ret := conf.Policy{Uid: -1, Gid: -1}
+	for _, p := range i.policies {
+		if matches(p, where) {
+			ret = p
+			break
+		}
+	}
+	if !ret.InheritOwner && -1 == ret.Uid {
+		ret.Uid, ret.Gid = i.defaults.Uid, i.defaults.Gid
+	}
+	if !ret.InheritFileMode && 0 == ret.FilePerm {
+		ret.FilePerm = i.defaults.FilePerm
+	}
+	if !ret.InheritDirMode && 0 == ret.DirPerm {
+		ret.DirPerm = i.defaults.DirPerm
+	}
+	if nil == ret.SetgidDirs {
+		ret.SetgidDirs = i.defaults.SetgidDirs
+	}
+	return ret
+}
+
+// SetPolicies replaces the default and per destination policies, used when
+// the configuration is reloaded.
+func (i *IoConf) SetPolicies(defaults conf.Policy, policies []conf.Policy) {
+	i.pmu.Lock()
+	i.defaults = defaults
+	i.policies = policies
+	i.pmu.Unlock()
+}
+
+// effective resolves the inherited parts of the policy from the destination
+// directory destDir.
+func effective(p conf.Policy, destDir string) (conf.Policy, error) {
+	if !p.InheritOwner && !p.InheritFileMode && !p.InheritDirMode {
+		return p, nil
+	}
+	info, err := os.Stat(destDir)
+	if nil != err {
+		return p, err
+	}
+	if p.InheritOwner {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			p.Uid, p.Gid = int(st.Uid), int(st.Gid)
+		}
+	}
+	if p.InheritDirMode {
+		p.DirPerm = info.Mode().Perm()
+	}
+	if p.InheritFileMode {
+		p.FilePerm = info.Mode().Perm() &^ 0111
+	}
+	return p, nil
+}
+
+func failure(err error) PathFailure {
+	if pe, ok := err.(*os.PathError); ok {
+		return PathFailure{Op: pe.Op, Path: pe.Path, Err: pe.Err}
+	}
+	return PathFailure{Op: "walk", Err: err}
+}
+
+// applyPolicy chowns and chmods dest and everything under it in a single
+// walk, symlinks are chowned themselves and never followed. It does not stop
+// at the first failure, all of them are returned in a *PolicyError. The size of
+// the regular files walked is returned.
+func applyPolicy(p conf.Policy, dest string) (int64, error) {
+	failed := make([]PathFailure, 0)
+	var size int64
+	filepath.Walk(dest, func(name string, info os.FileInfo, err error) error {
+		if nil != err {
+			f := failure(err)
+			f.Path = name
+			failed = append(failed, f)
+			return nil
+		}
+		if 0 != info.Mode()&os.ModeSymlink {
+			if err = os.Lchown(name, p.Uid, p.Gid); nil != err {
+				failed = append(failed, failure(err))
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		if err = os.Chown(name, p.Uid, p.Gid); nil != err {
+			failed = append(failed, failure(err))
+		}
+
+		perm := p.FilePerm
+		if info.IsDir() {
+			perm = p.DirPerm
+			if p.Setgid() {
+				if 0 == perm {
+					perm = info.Mode().Perm()
+				}
+				perm |= os.ModeSetgid
+			}
+		}
+		if 0 != perm {
+			if err = os.Chmod(name, perm); nil != err {
+				failed = append(failed, failure(err))
+			}
+		}
+		return nil
+	})
+	if 0 != len(failed) {
+		return size, &PolicyError{Failures: failed}
+	}
+	return size, nil
+}
@@ -0,0 +1,109 @@
+// Package logging sets up the leveled, structured slog default logger from the
+// configuration: logfmt or JSON records to stderr, a rotated file or the local
+// syslog.
+package logging
+
+import (
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+	OutputSyslog = "syslog"
+)
+
+var closer io.Closer
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if "" == level {
+		return slog.LevelInfo, nil
+	}
+	err := l.UnmarshalText([]byte(level))
+	if nil != err {
+		return l, errors.New("invalid log level " + level + ", should be debug, info, warn or error")
+	}
+	return l, nil
+}
+
+func newHandler(format string, w io.Writer, opts *slog.HandlerOptions) (slog.Handler, error) {
+	switch strings.ToLower(format) {
+	case "", FormatLogfmt:
+		return slog.NewTextHandler(w, opts), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, errors.New("invalid log format " + format + ", should be " + FormatLogfmt + " or " + FormatJSON)
+}
+
+// Setup replaces the default slog logger (and the standard log package
+// output) with one configured by c, closing the output of the previous Setup.
+func Setup(c conf.Logging) error {
+	level, err := ParseLevel(c.Level)
+	if nil != err {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	var newCloser io.Closer
+	switch strings.ToLower(c.Output) {
+	case "", OutputStderr:
+		handler, err = newHandler(c.Format, os.Stderr, opts)
+	case OutputFile:
+		if "" == c.File {
+			return errors.New("log output file needs log.file")
+		}
+		var rf *RotatingFile
+		if rf, err = OpenRotatingFile(c.File, int64(c.MaxSizeMB)<<20, c.MaxBackups); nil != err {
+			return err
+		}
+		newCloser = rf
+		handler, err = newHandler(c.Format, rf, opts)
+	case OutputSyslog:
+		handler, newCloser, err = newSyslogHandler(c.SyslogTag, func(w io.Writer) (slog.Handler, error) {
+			return newHandler(c.Format, w, opts)
+		})
+	default:
+		err = errors.New("invalid log output " + c.Output + ", should be " + OutputStderr + ", " + OutputFile + " or " + OutputSyslog)
+	}
+	if nil != err {
+		if nil != newCloser {
+			newCloser.Close()
+		}
+		return err
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	log.SetOutput(&stdlogWriter{logger: logger})
+	log.SetFlags(0)
+	if nil != closer {
+		closer.Close()
+	}
+	closer = newCloser
+	return nil
+}
+
+// stdlogWriter sends the output of the standard log package, ex: net/http
+// server errors, to the slog logger at error level.
+type stdlogWriter struct {
+	logger *slog.Logger
+}
+
+func (s *stdlogWriter) Write(p []byte) (int, error) {
+	s.logger.Error(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is an append only file rotated once it grows beyond maxSize,
+// keeping maxBackups older files as path.1 (most recent) to path.maxBackups.
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	fh         *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens path for appending, a maxSize <= 0 never rotates
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); nil != err {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	fh, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if nil != err {
+		return err
+	}
+	info, err := fh.Stat()
+	if nil != err {
+		fh.Close()
+		return err
+	}
+	rf.fh = fh
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	rf.fh.Close()
+	if rf.maxBackups > 0 {
+		for n := rf.maxBackups - 1; n > 0; n-- {
+			os.Rename(rf.path+"."+strconv.Itoa(n), rf.path+"."+strconv.Itoa(n+1))
+		}
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); nil != err {
+			return 0, err
+		}
+	}
+	n, err := rf.fh.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.fh.Close()
+}
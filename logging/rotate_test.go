@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remote-move.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if nil != err {
+		t.Fatalf("could not open log file %v", err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err = rf.Write([]byte(line)); nil != err {
+			t.Fatalf("could not write %v", err)
+		}
+	}
+
+	expect := map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	}
+	for p, content := range expect {
+		b, err := os.ReadFile(p)
+		if nil != err || content != string(b) {
+			t.Fatalf("%s should contain %q, got %q %v", p, content, b, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("only 2 backups should be kept")
+	}
+}
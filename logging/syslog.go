@@ -0,0 +1,76 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"sync"
+)
+
+// syslogSink writes every formatted record to the local syslog socket at the
+// priority of the record level.
+type syslogSink struct {
+	mu    sync.Mutex
+	w     *syslog.Writer
+	level slog.Level
+}
+
+func (s *syslogSink) Write(p []byte) (int, error) {
+	msg := string(p)
+	var err error
+	switch {
+	case s.level >= slog.LevelError:
+		err = s.w.Err(msg)
+	case s.level >= slog.LevelWarn:
+		err = s.w.Warning(msg)
+	case s.level >= slog.LevelInfo:
+		err = s.w.Info(msg)
+	default:
+		err = s.w.Debug(msg)
+	}
+	return len(p), err
+}
+
+// syslogHandler formats with the logfmt or json handler and hands the record
+// level over to the sink.
+type syslogHandler struct {
+	slog.Handler
+	sink *syslogSink
+}
+
+// newSyslogHandler returns the handler writing to the local syslog and the
+// connection to close once it is replaced
+func newSyslogHandler(tag string, newInner func(io.Writer) (slog.Handler, error)) (slog.Handler, io.Closer, error) {
+	if "" == tag {
+		tag = "remote-move"
+	}
+	w, err := syslog.Dial("", "", syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if nil != err {
+		return nil, nil, err
+	}
+	sink := &syslogSink{w: w}
+	inner, err := newInner(sink)
+	if nil != err {
+		w.Close()
+		return nil, nil, err
+	}
+	return &syslogHandler{Handler: inner, sink: sink}, w, nil
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	h.sink.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), sink: h.sink}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), sink: h.sink}
+}
@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package logging
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// newSyslogHandler fails, there is no local syslog on these platforms
+func newSyslogHandler(tag string, newInner func(io.Writer) (slog.Handler, error)) (slog.Handler, io.Closer, error) {
+	return nil, nil, errors.New("log output " + OutputSyslog + " is not supported on this platform")
+}
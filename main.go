@@ -1,18 +1,244 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/shoaib42/remote-move/conf"
 	"github.com/shoaib42/remote-move/io"
+	"github.com/shoaib42/remote-move/logging"
+	"github.com/shoaib42/remote-move/priv"
 	"github.com/shoaib42/remote-move/rest"
 )
 
+var (
+	configPath = flag.String("config", defaultConfigPath(), "path to the YAML configuration file (env "+conf.EnvPrefix+"CONFIG)")
+	listen     listFlag
+	assetsDir  = flag.String("assets", "", "directory with a customized index.html and static/, overrides assetsDir")
+	chown      = flag.String("chown", "", "usr:grp, names or ids, to chown to, overrides chownUsrGrp")
+	logLevel   = flag.String("log-level", "", "debug, info, warn or error, overrides log.level")
+)
+
+// listFlag is a flag that can be repeated, collecting every value
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func init() {
+	flag.Var(&listen, "listen", "address:port or unix:/path to listen on, repeatable, overrides listen, serverBindAddr and serverBindPort")
+}
+
+func defaultConfigPath() string {
+	if p, ok := os.LookupEnv(conf.EnvPrefix + "CONFIG"); ok {
+		return p
+	}
+	return "configuration.yaml"
+}
+
+// loadConfiguration loads the configuration file and environment into
+// conf.Confs with the command line flags on top.
+func loadConfiguration() error {
+	err := conf.LoadConfiguration(*configPath, func(c *conf.Configuration) {
+		if 0 != len(listen) {
+			c.Listen = listen
+		}
+		if "" != *assetsDir {
+			c.AssetsDir = *assetsDir
+		}
+		if "" != *logLevel {
+			c.Log.Level = *logLevel
+		}
+		if "" != *chown {
+			c.ChownUsrGrp = *chown
+		}
+	})
+	if nil != err {
+		return err
+	}
+	return logging.Setup(conf.Confs.Log)
+}
+
+func listenSummary() []string {
+	if 0 != len(conf.Confs.Listen) {
+		return conf.Confs.Listen
+	}
+	return []string{net.JoinHostPort(conf.Confs.ServerBindAddr, conf.Confs.ServerBindPort)}
+}
+
+// logSummary logs the effective configuration at startup
+func logSummary() {
+	roots := make([]string, 0, len(conf.Confs.DestRoots))
+	for _, r := range conf.Confs.DestRoots {
+		roots = append(roots, r.Name+"="+r.Path)
+	}
+	slog.Info("configuration",
+		"file", *configPath,
+		"srcDirs", conf.Confs.SrcDirs,
+		"destRoots", roots,
+		"excludeDirs", conf.Confs.ExcludeDirs,
+		"allowedCIDRs", conf.Confs.AllowedCIDRs,
+		"deniedCIDRs", conf.Confs.DeniedCIDRs,
+		"trustedProxies", conf.Confs.TrustedProxies,
+		"listen", listenSummary(),
+		"chownUsrGrp", conf.Confs.ChownUsrGrp,
+		"uid", conf.Confs.Uid,
+		"gid", conf.Confs.Gid,
+		"policies", len(conf.Confs.Policies),
+		"runAs", conf.Confs.RunAs,
+		"assetsDir", conf.Confs.AssetsDir,
+		"basePath", conf.Confs.BasePath,
+		"limits", conf.Confs.Limits,
+		"users", len(conf.Confs.Users),
+		"requireTwoFactorOutsideLAN", conf.Confs.TwoFactor.RequireOutsideLAN,
+		"stateDir", conf.Confs.StateDir,
+		"tls", "" != conf.Confs.TLS.Cert,
+		"clientCA", conf.Confs.TLS.ClientCA,
+		"forwardAuth", conf.Confs.ForwardAuth.Enabled,
+		"webhooks", len(conf.Confs.Webhooks),
+		"log", conf.Confs.Log.Output,
+	)
+}
+
+// reloaded are the settings a reload applies, the others need a restart
+var reloaded = []string{"chownUsrGrp", "fileMode", "dirMode", "umask", "setgidDirs", "policies", "log"}
+
+// restartNeeded lists the settings that differ between prev and next that a
+// reload does not apply
+func restartNeeded(prev, next *conf.Configuration) []string {
+	ret := make([]string, 0)
+	p, n := reflect.ValueOf(prev).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < p.NumField(); i++ {
+		name, _, _ := strings.Cut(p.Type().Field(i).Tag.Get("yaml"), ",")
+		if "" == name || "-" == name || slices.Contains(reloaded, name) {
+			continue
+		}
+		if !reflect.DeepEqual(p.Field(i).Interface(), n.Field(i).Interface()) {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+// reloadOnHup reloads the configuration on SIGHUP, re-resolving user and group
+// names, and applies the ownership and permission settings to iohelper and
+// the log settings. Changes to anything else are only logged.
+func reloadOnHup(iohelper io.IOHelpers) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		prev := conf.Confs
+		if err := loadConfiguration(); nil != err {
+			slog.Error("failed to reload configuration, keeping the previous one", "error", err)
+			continue
+		}
+		iohelper.SetPolicies(conf.Confs.DefaultPolicy(), conf.Confs.Policies)
+		slog.Info("configuration reloaded",
+			"chownUsrGrp", conf.Confs.ChownUsrGrp,
+			"uid", conf.Confs.Uid,
+			"gid", conf.Confs.Gid,
+			"fileMode", conf.Confs.FileMode,
+			"dirMode", conf.Confs.DirMode,
+			"umask", conf.Confs.Umask,
+			"setgidDirs", conf.Confs.SetgidDirs,
+			"policies", len(conf.Confs.Policies),
+			"log", conf.Confs.Log.Output,
+		)
+		if changed := restartNeeded(&prev, &conf.Confs); 0 != len(changed) {
+			slog.Warn("configuration changes need a restart to take effect", "settings", changed)
+		}
+	}
+}
+
+// shutdownTimeout bounds the wait for the requests and webhook deliveries in
+// progress on SIGTERM or SIGINT
+const shutdownTimeout = 30 * time.Second
+
+// shutdownOnSignal shuts server down on SIGTERM or SIGINT, closing done once
+// it is
+func shutdownOnSignal(server rest.RemoteMoveREST, done chan<- struct{}) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-stop
+	slog.Info("shutting down", "signal", sig.String(), "timeout", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); nil != err {
+		slog.Warn("shutdown did not complete", "error", err)
+	}
+	close(done)
+}
+
 func main() {
-	conf.LoadConfiguration("configuration.yaml")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "client":
+			os.Exit(runClient(os.Args[2:]))
+		case "mv", "cp":
+			os.Exit(runLocal(os.Args[1], os.Args[2:]))
+		case "passwd":
+			os.Exit(runPasswd(os.Args[2:]))
+		}
+	}
+	flag.Parse()
 
-	if iohelper, err := io.NewIOHelper(conf.Confs.SrcDirs, conf.Confs.DestRootDir, conf.Confs.ExcludeDirs, conf.Confs.Uid, conf.Confs.Gid); nil == err {
-		if server, err := rest.New("index.html", conf.Confs.ServerBindAddr, conf.Confs.ServerBindPort, conf.Confs.AllowedCIDRs, iohelper); nil == err {
-			server.Serve()
+	if err := loadConfiguration(); nil != err {
+		log.Fatal("failed to load configuration: " + err.Error())
+	}
+	if "" != conf.Confs.RunAs {
+		if err := priv.CanDrop(); nil != err {
+			log.Fatal("cannot drop privileges to runAs: " + err.Error())
 		}
 	}
+	logSummary()
 
+	iohelper, err := io.NewIOHelper(conf.Confs.SrcDirs, conf.Confs.DestRoots, conf.Confs.ExcludeDirs, conf.Confs.DefaultPolicy(), conf.Confs.Policies)
+	if nil != err {
+		log.Fatal(err)
+	}
+	server, err := rest.New(assets(), &conf.Confs, iohelper)
+	if nil != err {
+		log.Fatal(err)
+	}
+	if err = server.Listen(); nil != err {
+		log.Fatal(err)
+	}
+	if -1 != conf.Confs.RunAsUid {
+		if err = priv.Drop(conf.Confs.RunAsUid, conf.Confs.RunAsGid); nil != err {
+			log.Fatal("failed to drop privileges: " + err.Error())
+		}
+	}
+	if report, err := priv.Check(); nil != err {
+		slog.Warn("capability self-check failed", "error", err)
+	} else if report.Ok() {
+		slog.Info(report.String())
+	} else {
+		slog.Warn(report.String() + ", moves, copies and chowns may fail without the missing capabilities")
+	}
+
+	go reloadOnHup(iohelper)
+	done := make(chan struct{})
+	go shutdownOnSignal(server, done)
+	if err = server.Serve(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-done
 }
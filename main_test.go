@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestRestartNeeded(t *testing.T) {
+	prev := conf.Configuration{ChownUsrGrp: "media:media", Users: []conf.User{{Name: "alice"}}, Listen: []string{"127.0.0.1:8080"}}
+	next := prev
+	next.ChownUsrGrp = "root:media"
+	next.Log.Level = "debug"
+	if changed := restartNeeded(&prev, &next); 0 != len(changed) {
+		t.Fatalf("ownership and log changes are applied by a reload, got %v", changed)
+	}
+	next.Users = []conf.User{{Name: "bob"}}
+	next.Webhooks = []conf.Webhook{{Name: "all"}}
+	if changed := restartNeeded(&prev, &next); !reflect.DeepEqual([]string{"users", "webhooks"}, changed) {
+		t.Fatalf("users and webhooks changes need a restart, got %v", changed)
+	}
+}
@@ -0,0 +1,279 @@
+// Package metrics is a minimal Prometheus text exposition format registry
+// with counters, gauges and histograms, all with labels.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Sample is a single value of a gauge func
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics by name and writes them in name order
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is the registry served by the rest package
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	r.metrics[name] = m
+	r.mu.Unlock()
+}
+
+// WriteTo writes every metric in the text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for n := range r.metrics {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	ms := make([]metric, 0, len(names))
+	for _, n := range names {
+		ms = append(ms, r.metrics[n])
+	}
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range ms {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(w *bufio.Writer) {
+	w.WriteString("# HELP " + d.name + " " + strings.ReplaceAll(d.help, "\n", " ") + "\n")
+	w.WriteString("# TYPE " + d.name + " " + d.typ + "\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats the label pairs, extra is appended as is (used for le)
+func labelString(names, values []string, extra string) string {
+	if 0 == len(names) && "" == extra {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for n, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(values[n])+`"`)
+	}
+	if "" != extra {
+		parts = append(parts, extra)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// key joins label values into a map key
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter partitioned by labels, it doubles as a gauge with
+// Set when created by NewGaugeVec.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	lvs    map[string][]string
+}
+
+func (r *Registry) newVec(typ, name, help string, labels []string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		values: make(map[string]float64),
+		lvs:    make(map[string][]string),
+	}
+	r.register(name, c)
+	return c
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return r.newVec("counter", name, help, labels)
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *CounterVec {
+	return r.newVec("gauge", name, help, labels)
+}
+
+// Add adds v to the value of the label values, in the order of the labels
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	k := key(labelValues)
+	c.mu.Lock()
+	if _, ok := c.lvs[k]; !ok {
+		c.lvs[k] = append([]string(nil), labelValues...)
+	}
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Set(v float64, labelValues ...string) {
+	k := key(labelValues)
+	c.mu.Lock()
+	c.lvs[k] = append([]string(nil), labelValues...)
+	c.values[k] = v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.values) {
+		w.WriteString(c.name + labelString(c.labels, c.lvs[k], "") + " " + formatFloat(c.values[k]) + "\n")
+	}
+}
+
+// GaugeFunc is a gauge whose samples are collected at scrape time
+type GaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers collect, replacing any metric of the same name
+func (r *Registry) NewGaugeFunc(name, help string, collect func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, collect: collect}
+	r.register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.header(w)
+	for _, s := range g.collect() {
+		w.WriteString(g.name + labelString(g.labels, s.LabelValues, "") + " " + formatFloat(s.Value) + "\n")
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+	lvs     map[string][]string
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+		lvs:     make(map[string][]string),
+	}
+	r.register(name, h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	k := key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[k]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = hist
+		h.lvs[k] = append([]string(nil), labelValues...)
+	}
+	for n, b := range h.buckets {
+		if v <= b {
+			hist.counts[n]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// Since observes the seconds elapsed since start
+func (h *HistogramVec) Since(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.values) {
+		hist, lvs := h.values[k], h.lvs[k]
+		for n, b := range h.buckets {
+			w.WriteString(h.name + "_bucket" + labelString(h.labels, lvs, `le="`+formatFloat(b)+`"`) + " " + strconv.FormatUint(hist.counts[n], 10) + "\n")
+		}
+		w.WriteString(h.name + "_bucket" + labelString(h.labels, lvs, `le="+Inf"`) + " " + strconv.FormatUint(hist.count, 10) + "\n")
+		w.WriteString(h.name + "_sum" + labelString(h.labels, lvs, "") + " " + formatFloat(hist.sum) + "\n")
+		w.WriteString(h.name + "_count" + labelString(h.labels, lvs, "") + " " + strconv.FormatUint(hist.count, 10) + "\n")
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "A counter.", "op", "result")
+	c.Inc("move", "success")
+	c.Add(2, "copy", `bad "quote"`)
+	h := r.NewHistogramVec("test_seconds", "A histogram.", []float64{1, 5}, "op")
+	h.Observe(0.5, "move")
+	h.Observe(3, "move")
+	r.NewGaugeFunc("test_free", "A gauge func.", func() []Sample {
+		return []Sample{{LabelValues: []string{"media"}, Value: 42}}
+	}, "root")
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); nil != err {
+		t.Fatalf("failed to write metrics %v", err)
+	}
+	out := buf.String()
+	expect := []string{
+		"# TYPE test_total counter\n",
+		`test_total{op="move",result="success"} 1` + "\n",
+		`test_total{op="copy",result="bad \"quote\""} 2` + "\n",
+		"# TYPE test_seconds histogram\n",
+		`test_seconds_bucket{op="move",le="1"} 1` + "\n",
+		`test_seconds_bucket{op="move",le="5"} 2` + "\n",
+		`test_seconds_bucket{op="move",le="+Inf"} 2` + "\n",
+		`test_seconds_sum{op="move"} 3.5` + "\n",
+		`test_seconds_count{op="move"} 2` + "\n",
+		`test_free{root="media"} 42` + "\n",
+	}
+	for _, e := range expect {
+		if !strings.Contains(out, e) {
+			t.Fatalf("missing %q in\n%s", e, out)
+		}
+	}
+	if strings.Index(out, "test_free") > strings.Index(out, "test_seconds") {
+		t.Fatalf("metrics should be sorted by name\n%s", out)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package priv
+
+// cgoEnabled is set by builds with cgo, where the go runtime can't change
+// the credentials of every thread at once
+const cgoEnabled = false
@@ -0,0 +1,58 @@
+// Package priv drops root down to an unprivileged user that keeps only the
+// capabilities the io package needs, and reports which of those the process
+// holds.
+package priv
+
+import "strconv"
+
+// Cap is a linux capability number, see capabilities(7)
+type Cap uint
+
+const (
+	CapChown       Cap = 0
+	CapDacOverride Cap = 1
+	CapFowner      Cap = 3
+	CapFsetid      Cap = 4
+)
+
+var capNames = map[Cap]string{
+	CapChown:       "CAP_CHOWN",
+	CapDacOverride: "CAP_DAC_OVERRIDE",
+	CapFowner:      "CAP_FOWNER",
+	CapFsetid:      "CAP_FSETID",
+}
+
+func (c Cap) String() string {
+	return capNames[c]
+}
+
+// Needed are the capabilities kept after dropping root: chown to any user,
+// move/copy regardless of permissions, chmod files owned by others, and keep
+// setgid on directories whose group the process is not in.
+var Needed = []Cap{CapChown, CapDacOverride, CapFowner, CapFsetid}
+
+// Report is the result of the startup self-check
+type Report struct {
+	Uid     int
+	Present []Cap
+	Missing []Cap
+}
+
+// Ok reports if every needed capability is present
+func (r Report) Ok() bool {
+	return 0 == len(r.Missing)
+}
+
+func (r Report) String() string {
+	msg := "running as uid " + strconv.Itoa(r.Uid) + ", capabilities present:"
+	for _, c := range r.Present {
+		msg += " " + c.String()
+	}
+	if !r.Ok() {
+		msg += ", missing:"
+		for _, c := range r.Missing {
+			msg += " " + c.String()
+		}
+	}
+	return msg
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+package priv
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetKeepCaps       = 8
+	linuxCapabilityV3   = 0x20080522
+	capabilityU32s      = 2
+	procStatusCapEffKey = "CapEff:"
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// CanDrop tells, before anything is bound, why Drop would fail in this build
+func CanDrop() error {
+	if cgoEnabled {
+		return errors.New("runAs needs a build without cgo, rebuild with CGO_ENABLED=0")
+	}
+	return nil
+}
+
+// Drop switches every thread of the process to uid:gid, without any
+// supplementary groups, keeping only the Needed capabilities as permitted and
+// effective. It must be called as root, after binding the listeners.
+//
+// Capabilities are per thread, so they are set on all of them at once, which
+// the go runtime can only do when built without cgo (CGO_ENABLED=0).
+func Drop(uid, gid int) error {
+	if 0 != os.Geteuid() {
+		return errors.New("cannot drop privileges, not running as root")
+	}
+	if 0 == uid {
+		return errors.New("refusing to drop privileges to uid 0")
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetKeepCaps, 1, 0); 0 != errno {
+		if syscall.ENOTSUP == errno {
+			return errors.New("cannot drop privileges in a cgo enabled build, rebuild with CGO_ENABLED=0")
+		}
+		return errors.New("prctl(PR_SET_KEEPCAPS): " + errno.Error())
+	}
+	if err := syscall.Setgroups([]int{}); nil != err {
+		return errors.New("setgroups: " + err.Error())
+	}
+	if err := syscall.Setgid(gid); nil != err {
+		return errors.New("setgid: " + err.Error())
+	}
+	if err := syscall.Setuid(uid); nil != err {
+		return errors.New("setuid: " + err.Error())
+	}
+
+	hdr := capHeader{version: linuxCapabilityV3}
+	var data [capabilityU32s]capData
+	for _, c := range Needed {
+		data[c/32].effective |= 1 << (c % 32)
+		data[c/32].permitted |= 1 << (c % 32)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); 0 != errno {
+		return errors.New("capset: " + errno.Error())
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetKeepCaps, 0, 0); 0 != errno {
+		return errors.New("prctl(PR_SET_KEEPCAPS): " + errno.Error())
+	}
+	return nil
+}
+
+// Check reports which of the Needed capabilities the process holds, from the
+// effective set in /proc/self/status.
+func Check() (Report, error) {
+	fh, err := os.Open("/proc/self/status")
+	if nil != err {
+		return Report{Uid: os.Geteuid()}, err
+	}
+	defer fh.Close()
+	return checkStatus(fh, os.Geteuid())
+}
+
+// checkStatus reports the Needed capabilities of the effective set of status,
+// in the format of /proc/<pid>/status
+func checkStatus(status io.Reader, uid int) (Report, error) {
+	r := Report{Uid: uid}
+	var eff uint64
+	var err error
+	found := false
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, procStatusCapEffKey) {
+			eff, err = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, procStatusCapEffKey)), 16, 64)
+			if nil != err {
+				return r, err
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return r, errors.New("no " + procStatusCapEffKey + " in /proc/self/status")
+	}
+
+	for _, c := range Needed {
+		if 0 != eff&(1<<c) {
+			r.Present = append(r.Present, c)
+		} else {
+			r.Missing = append(r.Missing, c)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package priv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStatus(t *testing.T) {
+	status := "Name:\tremote-move\nCapInh:\t0000000000000000\nCapPrm:\t000000000000001b\nCapEff:\t%s\nCapBnd:\t000001ffffffffff\n"
+	cases := map[string][]Cap{
+		"000000000000001b": nil,
+		"000001ffffffffff": nil,
+		"0000000000000003": {CapFowner, CapFsetid},
+		"0000000000000000": Needed,
+	}
+	for eff, missing := range cases {
+		r, err := checkStatus(strings.NewReader(strings.Replace(status, "%s", eff, 1)), 1000)
+		if nil != err || len(missing) != len(r.Missing) || len(Needed) != len(r.Present)+len(r.Missing) || (0 == len(missing)) != r.Ok() {
+			t.Fatalf("CapEff %s should miss %v, got %v %v", eff, missing, r, err)
+		}
+		for n, c := range missing {
+			if c != r.Missing[n] {
+				t.Fatalf("CapEff %s should miss %v, got %v", eff, missing, r.Missing)
+			}
+		}
+	}
+	if r, _ := checkStatus(strings.NewReader(strings.Replace(status, "%s", "0000000000000005", 1)), 1000); "running as uid 1000, capabilities present: CAP_CHOWN, missing: CAP_DAC_OVERRIDE CAP_FOWNER CAP_FSETID" != r.String() {
+		t.Fatalf("unexpected report %s", r.String())
+	}
+	for name, s := range map[string]string{"no CapEff": "Name:\tx\n", "not hex": "CapEff:\tnope\n"} {
+		if _, err := checkStatus(strings.NewReader(s), 0); nil == err {
+			t.Fatalf("%s should be an error", name)
+		}
+	}
+}
+
+func TestCanDrop(t *testing.T) {
+	if err := CanDrop(); cgoEnabled == (nil == err) {
+		t.Fatalf("CanDrop should refuse cgo builds only, cgo %v, got %v", cgoEnabled, err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package priv
+
+import (
+	"errors"
+	"os"
+)
+
+// CanDrop tells, before anything is bound, why Drop would fail
+func CanDrop() error {
+	return errors.New("runAs is only supported on linux")
+}
+
+// Drop is only supported on linux
+func Drop(uid, gid int) error {
+	return errors.New("dropping privileges is only supported on linux")
+}
+
+// Check can only tell if the process runs as root outside of linux
+func Check() (Report, error) {
+	r := Report{Uid: os.Geteuid()}
+	if 0 == r.Uid {
+		r.Present = Needed
+	} else {
+		r.Missing = Needed
+	}
+	return r, nil
+}
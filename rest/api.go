@@ -0,0 +1,396 @@
+package rest
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/io"
+	"github.com/shoaib42/remote-move/webhook"
+)
+
+// ioErrors maps the kinds of errors of the io package to their status and code
+var ioErrors = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{io.ErrInvalid, http.StatusBadRequest, api.CodeBadRequest},
+	{io.ErrInvalidName, http.StatusBadRequest, api.CodeInvalidName},
+	{io.ErrNotFound, http.StatusNotFound, api.CodeNotFound},
+	{io.ErrNotAccessible, http.StatusForbidden, api.CodeNotAccessible},
+	{io.ErrPermission, http.StatusForbidden, api.CodePermission},
+	{io.ErrConflict, http.StatusConflict, api.CodeConflict},
+	{io.ErrCrossDevice, http.StatusConflict, api.CodeCrossDevice},
+	{io.ErrNoSpace, http.StatusInsufficientStorage, api.CodeNoSpace},
+	{fs.ErrNotExist, http.StatusNotFound, api.CodeNotFound},
+	{fs.ErrExist, http.StatusConflict, api.CodeConflict},
+	{fs.ErrPermission, http.StatusForbidden, api.CodePermission},
+}
+
+// maxOperations is how many finished operations are kept for /operations
+const maxOperations = 100
+
+// sourceID is the unpadded base64url of the path, so it is a single path
+// segment the mux does not clean, ex: /srv/dl gives L3Nydi9kbA
+func sourceID(path string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+// operations keeps the last maxOperations operations, oldest first
+type operations struct {
+	mu   sync.Mutex
+	list []*api.Operation
+}
+
+func (o *operations) add(op *api.Operation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.list = append(o.list, op)
+	if len(o.list) > maxOperations {
+		o.list = o.list[len(o.list)-maxOperations:]
+	}
+}
+
+// of returns the operations of a user, the anonymous ones for ""
+func (o *operations) of(user string) []*api.Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ret := make([]*api.Operation, 0, len(o.list))
+	for _, op := range o.list {
+		if op.User == user {
+			ret = append(ret, op)
+		}
+	}
+	return ret
+}
+
+func (o *operations) get(id, user string) *api.Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, op := range o.list {
+		if op.ID == id && op.User == user {
+			return op
+		}
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func isAPIRequest(r *http.Request, basePath string) bool {
+	return strings.HasPrefix(r.URL.Path, basePath+api.APIPrefix+"/") || r.URL.Path == basePath+api.APIPrefix
+}
+
+// writeError answers a JSON error on the API routes and plain text elsewhere
+func (h *Handle) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if !isAPIRequest(r, h.basePath) {
+		http.Error(w, message, status)
+		return
+	}
+	writeJSON(w, status, api.ErrorResponse{Error: api.APIError{Code: code, Message: message}})
+}
+
+// ErrorCode is the API error code of an error of the io package
+func ErrorCode(err error) string {
+	_, apiErr := apiError(err)
+	return apiErr.Code
+}
+
+func (h *Handle) methodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	h.writeError(w, r, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed, "Method not allowed")
+}
+
+// apiError maps an error of the io package to its status and API error
+func apiError(err error) (int, *api.APIError) {
+	var pe *io.PolicyError
+	if errors.As(err, &pe) {
+		details := make([]api.APIErrorDetail, 0, len(pe.Failures))
+		for _, f := range pe.Failures {
+			details = append(details, api.APIErrorDetail{Operation: f.Op, Path: f.Path, Message: f.Err.Error()})
+		}
+		return http.StatusInternalServerError, &api.APIError{Code: api.CodeOwnershipFailed, Message: err.Error(), Details: details}
+	}
+	for _, e := range ioErrors {
+		if errors.Is(err, e.kind) {
+			return e.status, &api.APIError{Code: e.code, Message: err.Error()}
+		}
+	}
+	return http.StatusInternalServerError, &api.APIError{Code: api.CodeInternal, Message: err.Error()}
+}
+
+// pathID returns the last segment of the path after prefix, ex: movies for
+// /api/v1/destinations/movies
+func (h *Handle) pathID(r *http.Request, prefix string) (string, bool) {
+	id := strings.TrimPrefix(r.URL.Path, h.basePath+api.APIPrefix+prefix)
+	return id, "" != id && !strings.Contains(id, "/")
+}
+
+func (h *Handle) sources(perms *auth.Permissions) ([]api.Source, error) {
+	mup, err := h.filedir.GetSrcMapItems()
+	if nil != err {
+		return nil, err
+	}
+	mup = readable(perms, mup)
+	ret := make([]api.Source, 0, len(mup))
+	for path, items := range mup {
+		ret = append(ret, api.Source{ID: sourceID(path), Path: path, Items: items})
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Path < ret[b].Path })
+	return ret, nil
+}
+
+func (h *Handle) destinations(perms *auth.Permissions) ([]api.Destination, error) {
+	ddir, err := h.filedir.GetDestDirList()
+	if nil != err {
+		return nil, err
+	}
+	ddir = writable(perms, ddir)
+	ret := make([]api.Destination, 0, len(ddir))
+	for root, subdirs := range ddir {
+		d := api.Destination{Root: root, Subdirs: make([]api.DestinationSubdir, 0, len(subdirs))}
+		for _, s := range subdirs {
+			where := root + "/" + s
+			d.Subdirs = append(d.Subdirs, api.DestinationSubdir{Name: s, Path: where, Policy: toPolicyResponse(h.filedir.GetDestPolicy(where))})
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(a, b int) bool { return ret[a].Root < ret[b].Root })
+	return ret, nil
+}
+
+func (h *Handle) handleSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	srcs, err := h.sources(perms)
+	if nil != err {
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Errors getting directory listing for sources")
+		return
+	}
+	writeJSON(w, http.StatusOK, srcs)
+}
+
+func (h *Handle) handleSource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	id, ok := h.pathID(r, "/sources/")
+	if !ok {
+		h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Source not found")
+		return
+	}
+	srcs, err := h.sources(perms)
+	if nil != err {
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Errors getting directory listing for sources")
+		return
+	}
+	for _, s := range srcs {
+		if s.ID == id {
+			writeJSON(w, http.StatusOK, s)
+			return
+		}
+	}
+	h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Source not found")
+}
+
+func (h *Handle) handleDestinations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	dests, err := h.destinations(perms)
+	if nil != err {
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Errors getting directory listing for destinations")
+		return
+	}
+	writeJSON(w, http.StatusOK, dests)
+}
+
+func (h *Handle) handleDestination(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	id, ok := h.pathID(r, "/destinations/")
+	if !ok {
+		h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Destination root not found")
+		return
+	}
+	dests, err := h.destinations(perms)
+	if nil != err {
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Errors getting directory listing for destinations")
+		return
+	}
+	for _, d := range dests {
+		if d.Root == id {
+			writeJSON(w, http.StatusOK, d)
+			return
+		}
+	}
+	h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Destination root not found")
+}
+
+func (h *Handle) handleOperations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := h.permissions(w, r); ok {
+			id, _ := identity(r)
+			writeJSON(w, http.StatusOK, h.operations.of(id.Name))
+		}
+	case http.MethodPost:
+		h.createOperation(w, r)
+	default:
+		h.methodNotAllowed(w, r, "GET, POST")
+	}
+}
+
+func (h *Handle) handleOperation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	if _, ok := h.permissions(w, r); !ok {
+		return
+	}
+	user, _ := identity(r)
+	id, ok := h.pathID(r, "/operations/")
+	var op *api.Operation
+	if ok {
+		op = h.operations.get(id, user.Name)
+	}
+	if nil == op {
+		h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Operation not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, op)
+}
+
+func (h *Handle) createOperation(w http.ResponseWriter, r *http.Request) {
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	var req api.OperationRequest
+	if !h.decodeBody(w, r, &req) || !h.checkItems(w, r, req.Items) {
+		return
+	}
+	do := h.filedir.DoMvChown
+	switch req.Operation {
+	case "move":
+	case "copy":
+		do = h.filedir.DoCpChown
+	default:
+		h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "operation should be move or copy")
+		return
+	}
+	if 0 == len(req.Items) {
+		h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "no items to "+req.Operation)
+		return
+	}
+	if !h.authorize(w, r, perms, req.Operation, req.Src, req.Dest) {
+		return
+	}
+
+	op := h.newOperation(r, req.Operation, req.Src, req.Dest)
+	for _, i := range req.Items {
+		op.Results = append(op.Results, itemResult(i, do(req.Src, i, req.Dest)))
+	}
+	h.recordOperation(r, op)
+
+	w.Header().Set("Location", h.basePath+api.APIPrefix+"/operations/"+op.ID)
+	writeJSON(w, op.Status, op)
+}
+
+// newOperation starts the operation op of the client of r
+func (h *Handle) newOperation(r *http.Request, op, src, dest string) *api.Operation {
+	user := ""
+	if who, ok := identity(r); ok {
+		user = who.Name
+	}
+	return &api.Operation{ID: newID(), User: user, Operation: op, Src: src, Dest: dest, Started: time.Now(), Results: make([]api.ItemResult, 0)}
+}
+
+// itemResult is the outcome of item, err being what doing it returned
+func itemResult(item string, err error) api.ItemResult {
+	res := api.ItemResult{Item: item, Status: http.StatusOK}
+	if nil != err {
+		res.Status, res.Error = apiError(err)
+	}
+	return res
+}
+
+// recordOperation finishes op, keeps it for /api/v1/operations and fires the
+// webhooks of its outcome
+func (h *Handle) recordOperation(r *http.Request, op *api.Operation) {
+	op.Finished = time.Now()
+	failedStatus := 0
+	failed := 0
+	items := make([]webhook.Item, 0, len(op.Results))
+	for _, res := range op.Results {
+		item := webhook.Item{Item: res.Item, Status: res.Status}
+		if nil != res.Error {
+			item.Error = res.Error.Message
+			if 0 != failed && failedStatus != res.Status {
+				failedStatus = http.StatusMultiStatus
+			} else {
+				failedStatus = res.Status
+			}
+			failed++
+		}
+		items = append(items, item)
+	}
+	switch {
+	case 0 == failed:
+		op.Status = http.StatusCreated
+	case failed < len(op.Results):
+		op.Status = http.StatusMultiStatus
+	default:
+		op.Status = failedStatus
+	}
+	h.operations.add(op)
+	h.fireOperation(r, op.ID, op.Operation, op.Src, op.Dest, items)
+}
+
+func (h *Handle) handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Not found")
+}
@@ -0,0 +1,99 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+type asset struct {
+	content []byte
+	hash    string
+}
+
+// webAssets holds the index, rendered once, and the static files in memory,
+// each with its content hash. Static files requested with ?v=<hash>, as the
+// index links them, are cached forever by the browser, everything else is
+// revalidated with its ETag.
+type webAssets struct {
+	basePath string
+	index    asset
+	static   map[string]asset
+}
+
+func contentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// loadAssets reads index.html and static/ from fsys. index.html is an
+// html/template where {{asset "static/app.js"}} gives the hashed url under
+// basePath, and {{.BasePath}} the base path the frontend builds urls from.
+func loadAssets(fsys fs.FS, basePath string) (*webAssets, error) {
+	a := &webAssets{basePath: basePath, static: make(map[string]asset)}
+	err := fs.WalkDir(fsys, "static", func(name string, d fs.DirEntry, err error) error {
+		if nil != err || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if nil != err {
+			return err
+		}
+		a.static[name] = asset{content: content, hash: contentHash(content)}
+		return nil
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	tmpl, err := template.New("index.html").Funcs(template.FuncMap{"asset": a.url}).ParseFS(fsys, "index.html")
+	if nil != err {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, struct{ BasePath string }{basePath}); nil != err {
+		return nil, err
+	}
+	a.index = asset{content: buf.Bytes(), hash: contentHash(buf.Bytes())}
+	return a, nil
+}
+
+// url returns the cache busting url of a static file
+func (a *webAssets) url(name string) string {
+	if f, ok := a.static[name]; ok {
+		return a.basePath + "/" + name + "?v=" + f.hash
+	}
+	return a.basePath + "/" + name
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, name string, f asset, cacheControl string) {
+	w.Header().Set("ETag", `"`+f.hash+`"`)
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(f.content))
+}
+
+func (a *webAssets) serveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	serveAsset(w, r, "index.html", a.index, "no-cache")
+}
+
+func (a *webAssets) serveStatic(w http.ResponseWriter, r *http.Request) {
+	name := "static/" + strings.TrimPrefix(r.URL.Path, a.basePath+"/static/")
+	f, ok := a.static[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cacheControl := "no-cache"
+	if f.hash == r.URL.Query().Get("v") {
+		cacheControl = immutableCacheControl
+	}
+	serveAsset(w, r, name, f, cacheControl)
+}
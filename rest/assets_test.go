@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssets(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":    {Data: []byte(`<meta name="base-path" content="{{.BasePath}}"><script src="{{asset "static/app.js"}}"></script>`)},
+		"static/app.js": {Data: []byte("console.log(1)")},
+	}
+	a, err := loadAssets(fsys, "/remote-move")
+	if nil != err {
+		t.Fatalf("could not load assets %v", err)
+	}
+
+	hash := contentHash([]byte("console.log(1)"))
+	rec := httptest.NewRecorder()
+	a.serveIndex(rec, httptest.NewRequest(http.MethodGet, "/remote-move/", nil))
+	if !strings.Contains(rec.Body.String(), `src="/remote-move/static/app.js?v=`+hash+`"`) {
+		t.Fatalf("index should link the hashed asset, got %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `content="/remote-move"`) {
+		t.Fatalf("index should link the hashed asset, got %s", rec.Body.String())
+	}
+	if "no-cache" != rec.Header().Get("Cache-Control") {
+		t.Fatalf("index should be revalidated, got %s", rec.Header().Get("Cache-Control"))
+	}
+
+	rec = httptest.NewRecorder()
+	a.serveStatic(rec, httptest.NewRequest(http.MethodGet, "/remote-move/static/app.js?v="+hash, nil))
+	if http.StatusOK != rec.Code || immutableCacheControl != rec.Header().Get("Cache-Control") {
+		t.Fatalf("hashed asset should be cached forever, got %d %s", rec.Code, rec.Header().Get("Cache-Control"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/remote-move/static/app.js", nil)
+	req.Header.Set("If-None-Match", `"`+hash+`"`)
+	rec = httptest.NewRecorder()
+	a.serveStatic(rec, req)
+	if http.StatusNotModified != rec.Code {
+		t.Fatalf("asset with a matching etag should not be sent again, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	a.serveStatic(rec, httptest.NewRequest(http.MethodGet, "/remote-move/static/nope.js", nil))
+	if http.StatusNotFound != rec.Code {
+		t.Fatalf("unknown asset should be 404, got %d", rec.Code)
+	}
+}
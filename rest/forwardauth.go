@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// forwardAuth is the identity an authenticating proxy in front of the
+// server, ex: Authelia, passes in headers
+type forwardAuth struct {
+	proxies      []netip.Prefix
+	userHeader   string
+	groupsHeader string
+	groups       map[string][]string
+}
+
+// newForwardAuth returns the forward auth of c, nil when it is disabled
+func newForwardAuth(c conf.ForwardAuth, trustedProxies []string) (*forwardAuth, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	proxies := c.Proxies
+	if 0 == len(proxies) {
+		proxies = trustedProxies
+	}
+	prefixes, err := parsePrefixes(proxies)
+	if nil != err {
+		return nil, err
+	}
+	f := &forwardAuth{
+		proxies:      prefixes,
+		userHeader:   http.CanonicalHeaderKey(c.UserHeader),
+		groupsHeader: http.CanonicalHeaderKey(c.GroupsHeader),
+		groups:       make(map[string][]string, len(c.Groups)),
+	}
+	for _, g := range c.Groups {
+		f.groups[g.Group] = append(f.groups[g.Group], g.Roles...)
+	}
+	return f, nil
+}
+
+// fromProxy reports if the peer of r, not the forwarded client, is a proxy
+func (f *forwardAuth) fromProxy(r *http.Request) bool {
+	peer, ok := peerAddr(r)
+	return ok && containsAddr(f.proxies, peer)
+}
+
+// roles are the roles the groups, comma separated, map to
+func (f *forwardAuth) roles(groups string) []string {
+	var ret []string
+	for _, g := range strings.Split(groups, ",") {
+		for _, role := range f.groups[strings.TrimSpace(g)] {
+			if !slices.Contains(ret, role) {
+				ret = append(ret, role)
+			}
+		}
+	}
+	return ret
+}
+
+// proxyIdentity is the user the proxy authenticated, nil without forward
+// auth or its headers. The headers coming from anywhere but a proxy are
+// answered with a 403, someone is trying to pass for another user.
+func (h *Handle) proxyIdentity(w http.ResponseWriter, r *http.Request) (*auth.Identity, bool) {
+	f := h.forwardAuth
+	if nil == f {
+		return nil, true
+	}
+	_, hasUser := r.Header[f.userHeader]
+	_, hasGroups := r.Header[f.groupsHeader]
+	if !hasUser && !hasGroups {
+		return nil, true
+	}
+	if !f.fromProxy(r) {
+		rejectedRequests.Inc("forward_auth")
+		slog.Warn("forward auth headers from an untrusted peer", "peer", r.RemoteAddr, "user", r.Header.Get(f.userHeader))
+		h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Identity headers are only accepted from the proxy")
+		return nil, false
+	}
+	name := strings.TrimSpace(r.Header.Get(f.userHeader))
+	if "" == name {
+		return nil, true
+	}
+	roles := f.roles(r.Header.Get(f.groupsHeader))
+	if userRoles, ok := h.authority.Roles(name); ok {
+		for _, role := range userRoles {
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return &auth.Identity{Name: name, Roles: roles, Via: auth.ViaProxy}, true
+}
@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestForwardAuth(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+	h := newTestHandle(t, &conf.Configuration{
+		AllowedCIDRs:   []string{"192.0.2.0/24", "10.0.0.5"},
+		TrustedProxies: []string{"10.0.0.5"},
+		Roles: []conf.Role{
+			{Name: "mover", Sources: []string{"*"}, Destinations: []string{"*"}, Operations: []string{"move"}},
+			{Name: "copier", Sources: []string{"*"}, Destinations: []string{"*"}, Operations: []string{"copy"}},
+		},
+		Users: []conf.User{{Name: "alice", Password: hash, Roles: []string{"copier"}}},
+		ForwardAuth: conf.ForwardAuth{
+			Enabled:      true,
+			UserHeader:   "Remote-User",
+			GroupsHeader: "Remote-Groups",
+			Groups:       []conf.GroupRoles{{Group: "family", Roles: []string{"mover"}}},
+		},
+	})
+	handler := h.handler()
+	token, _, err := h.tokens.Create(auth.Token{Name: "scripts", Expires: time.Now().Add(time.Hour), Operations: []string{"move"}, Sources: []string{"*"}, Destinations: []string{"*"}})
+	if nil != err {
+		t.Fatalf("could not create a token %v", err)
+	}
+
+	get := func(peer string, headers map[string]string) (int, api.SessionResponse) {
+		rec := sendRequest(handler, http.MethodGet, "/api/v1/session", peer+":1", "", nil, headers)
+		var sess api.SessionResponse
+		json.NewDecoder(rec.Body).Decode(&sess)
+		return rec.Code, sess
+	}
+
+	if code, _ := get("10.0.0.5", map[string]string{"X-Forwarded-For": "192.0.2.7"}); http.StatusUnauthorized != code {
+		t.Fatalf("without the headers the client should log in, got %d", code)
+	}
+	code, sess := get("10.0.0.5", map[string]string{"X-Forwarded-For": "192.0.2.7", "Remote-User": "bob", "Remote-Groups": "family, other"})
+	if http.StatusOK != code || "bob" != sess.User || auth.ViaProxy != sess.Via || 1 != len(sess.Operations) || "move" != sess.Operations[0] {
+		t.Fatalf("bob of family should be a mover, got %d %v", code, sess)
+	}
+	code, sess = get("10.0.0.5", map[string]string{"Remote-User": "alice", "Remote-Groups": "family"})
+	if http.StatusOK != code || 2 != len(sess.Roles) {
+		t.Fatalf("alice should have the roles of the user and of the groups, got %d %v", code, sess)
+	}
+	if code, _ = get("192.0.2.7", map[string]string{"Remote-User": "alice"}); http.StatusForbidden != code {
+		t.Fatalf("identity headers from a client should be refused, got %d", code)
+	}
+	if code, _ = get("192.0.2.7", map[string]string{"Remote-Groups": "family"}); http.StatusForbidden != code {
+		t.Fatalf("groups headers from a client should be refused, got %d", code)
+	}
+	if code, _ = get("192.0.2.7", map[string]string{"Authorization": "Bearer " + token}); http.StatusOK != code {
+		t.Fatalf("the token should be accepted from a client, got %d", code)
+	}
+	if code, _ = get("192.0.2.7", map[string]string{"Authorization": "Bearer " + token, "Remote-User": "alice"}); http.StatusForbidden != code {
+		t.Fatalf("identity headers from a client should be refused along with a token, got %d", code)
+	}
+}
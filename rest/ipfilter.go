@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+type ctxKey int
+
+const (
+	clientIPKey ctxKey = iota
+	identityKey
+)
+
+// UnixPeer stands for the clients of the unix sockets in the lists of IPs and
+// CIDRs. They are no IP, so no CIDR contains them.
+const UnixPeer = "unix"
+
+// unixAddr is the address of the clients of the unix sockets, the
+// unspecified address no tcp peer nor parsed address has
+var unixAddr = netip.IPv6Unspecified()
+
+var unixPrefix = netip.PrefixFrom(unixAddr, unixAddr.BitLen())
+
+// parsePrefixes parses IPs and CIDRs, v4 and v6, and UnixPeer. A bare IP is
+// a single host prefix (/32 or /128) and v4-mapped v6 addresses are turned
+// into v4 ones.
+func parsePrefixes(ipsOrCIDRs []string) ([]netip.Prefix, error) {
+	ret := make([]netip.Prefix, 0, len(ipsOrCIDRs))
+	for _, ipOrCIDR := range ipsOrCIDRs {
+		if UnixPeer == ipOrCIDR {
+			ret = append(ret, unixPrefix)
+			continue
+		}
+		if addr, err := netip.ParseAddr(ipOrCIDR); nil == err {
+			if addr.IsUnspecified() {
+				return nil, errors.New("Invalid IP " + ipOrCIDR + ", no client has it")
+			}
+			addr = addr.Unmap().WithZone("")
+			ret = append(ret, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(ipOrCIDR)
+		if nil != err {
+			return nil, errors.New("Invalid IP or CIDR " + ipOrCIDR)
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		ret = append(ret, p.Masked())
+	}
+	return ret, nil
+}
+
+// containsAddr reports if a prefix contains addr, the unix socket clients
+// being only in the lists with UnixPeer
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if unixAddr == addr {
+		return slices.Contains(prefixes, unixPrefix)
+	}
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddr parses an address, with or without port, as found in the
+// forwarded headers. The unspecified address is refused, it would pass for a
+// unix socket client.
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); nil == err {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if nil != err || addr.IsUnspecified() {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// peerAddr is the address of the peer of r, not the forwarded client
+func peerAddr(r *http.Request) (netip.Addr, bool) {
+	if UnixPeer == r.RemoteAddr {
+		return unixAddr, true
+	}
+	return parseAddr(r.RemoteAddr)
+}
+
+// addrString is addr for the logs
+func addrString(addr netip.Addr) string {
+	if unixAddr == addr {
+		return UnixPeer
+	}
+	return addr.String()
+}
+
+// forwardedFor returns the chain of addresses a request was forwarded for,
+// client first, from the Forwarded header or else X-Forwarded-For.
+func forwardedFor(r *http.Request) []string {
+	chain := make([]string, 0)
+	if fwd := r.Header.Values("Forwarded"); 0 != len(fwd) {
+		for _, element := range strings.Split(strings.Join(fwd, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold("for", k) {
+					chain = append(chain, strings.Trim(v, `"`))
+				}
+			}
+		}
+		return chain
+	}
+	for _, xff := range r.Header.Values("X-Forwarded-For") {
+		chain = append(chain, strings.Split(xff, ",")...)
+	}
+	return chain
+}
+
+// resolveClientIP is the peer address, unless the peer is a trusted proxy in
+// which case the forwarded chain is walked from the right, the first address
+// not being a trusted proxy is the client.
+func resolveClientIP(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	addr, ok := peerAddr(r)
+	if !ok || !containsAddr(trustedProxies, addr) {
+		return addr, ok
+	}
+	chain := forwardedFor(r)
+	for n := len(chain) - 1; n >= 0; n-- {
+		hop, ok := parseAddr(chain[n])
+		if !ok {
+			// obfuscated or unknown identifier, can't go further
+			return netip.Addr{}, false
+		}
+		addr = hop
+		if !containsAddr(trustedProxies, hop) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// clientIP returns the address resolved by the clientIPMiddleware
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	addr, ok := r.Context().Value(clientIPKey).(netip.Addr)
+	return addr, ok && addr.IsValid()
+}
+
+// clientIPMiddleware resolves the client address once for everything after it
+func (h *Handle) clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr, ok := resolveClientIP(r, h.trustedProxies); ok {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey, addr))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ipRestrictionMiddleware lets in the clients of the allowedCIDRs, and those
+// having a client certificate clientCerts maps when they bypass the
+// allowedCIDRs, but never the deniedCIDRs
+func (h *Handle) ipRestrictionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := clientIP(r)
+		allowed := containsAddr(h.allowedCIDRs, addr) || (h.certsBypassCIDRs && nil != h.certIdentity(r))
+		if !ok || containsAddr(h.deniedCIDRs, addr) || !allowed {
+			rejectedRequests.Inc("ip")
+			h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes([]string{"192.168.1.10", "2001:db8::1", "::ffff:10.0.0.0/104", "fd00::/8"})
+	if nil != err {
+		t.Fatalf("failed to parse prefixes %v", err)
+	}
+	expect := []string{"192.168.1.10/32", "2001:db8::1/128", "10.0.0.0/8", "fd00::/8"}
+	for n, p := range prefixes {
+		if expect[n] != p.String() {
+			t.Fatalf("expected %s, got %s", expect[n], p)
+		}
+	}
+	if _, err = parsePrefixes([]string{"192.168.1.300"}); nil == err {
+		t.Fatalf("invalid ip should be rejected")
+	}
+}
+
+func TestIPRestriction(t *testing.T) {
+	allowed, _ := parsePrefixes([]string{"192.168.0.0/24", "fd00::/8"})
+	denied, _ := parsePrefixes([]string{"192.168.0.66"})
+	trusted, _ := parsePrefixes([]string{"127.0.0.1", "::1"})
+	h := &Handle{allowedCIDRs: allowed, deniedCIDRs: denied, trustedProxies: trusted}
+	handler := h.clientIPMiddleware(h.ipRestrictionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	check := func(remote string, headers map[string]string, expect int) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if expect != rec.Code {
+			t.Fatalf("%s %v should get %d, got %d", remote, headers, expect, rec.Code)
+		}
+	}
+
+	check("192.168.0.5:1234", nil, http.StatusOK)
+	check("[::ffff:192.168.0.5]:1234", nil, http.StatusOK)
+	check("[fd12::5]:1234", nil, http.StatusOK)
+	check("192.168.0.66:1234", nil, http.StatusForbidden)
+	check("10.0.0.5:1234", nil, http.StatusForbidden)
+	// behind the proxy
+	check("127.0.0.1:1234", nil, http.StatusForbidden)
+	check("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.168.0.5"}, http.StatusOK)
+	check("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.168.0.5, 10.0.0.5"}, http.StatusForbidden)
+	check("[::1]:1234", map[string]string{"Forwarded": `for="[fd12::5]:4711";proto=https`}, http.StatusOK)
+	check("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.168.0.66"}, http.StatusForbidden)
+	// headers from anyone else are ignored
+	check("10.0.0.5:1234", map[string]string{"X-Forwarded-For": "192.168.0.5"}, http.StatusForbidden)
+	// unix socket clients are not 127.0.0.1, and can't be forged
+	check(UnixPeer, nil, http.StatusForbidden)
+	check(UnixPeer, map[string]string{"X-Forwarded-For": "192.168.0.5"}, http.StatusForbidden)
+	h.allowedCIDRs, _ = parsePrefixes([]string{"::/0", UnixPeer})
+	check(UnixPeer, nil, http.StatusOK)
+	check("127.0.0.1:1234", map[string]string{"X-Forwarded-For": "::"}, http.StatusForbidden)
+	h.allowedCIDRs, _ = parsePrefixes([]string{"::/0"})
+	check(UnixPeer, nil, http.StatusForbidden)
+}
@@ -0,0 +1,133 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+// bucket holds the tokens of a client, refilled at the rate of the limiter
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per client ip
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[netip.Addr]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// newRateLimiter returns nil, no limit, when rate is not positive
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[netip.Addr]*bucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of addr, when there is none it returns
+// how long until there is one
+func (l *rateLimiter) allow(addr netip.Addr) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets, once a minute, the clients whose bucket is full again
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, addr)
+		}
+	}
+}
+
+// rateLimit applies the listing budget to the safe methods of the API routes
+// and the mutation budget to the others, answering 429 once exhausted
+func (h *Handle) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := h.listingLimiter
+		if isUnsafeMethod(r.Method) {
+			limiter = h.mutationLimiter
+		}
+		addr, ok := clientIP(r)
+		if nil == limiter || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if allowed, wait := limiter.allow(addr); !allowed {
+			rejectedRequests.Inc("rate_limit")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			h.writeError(w, r, http.StatusTooManyRequests, api.CodeRateLimited, "Too many requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeBody decodes the json body of r into v, bounded by maxBodyBytes, and
+// answers the error when it fails
+func (h *Handle) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	body := r.Body
+	if h.maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, h.maxBodyBytes)
+	}
+	err := json.NewDecoder(body).Decode(v)
+	if nil == err {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.writeError(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "Request body larger than "+strconv.FormatInt(h.maxBodyBytes, 10)+" bytes")
+		return false
+	}
+	h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid request body")
+	return false
+}
+
+// checkItems answers an error when there are more items than maxItems
+func (h *Handle) checkItems(w http.ResponseWriter, r *http.Request, items []string) bool {
+	if h.maxItems > 0 && len(items) > h.maxItems {
+		h.writeError(w, r, http.StatusRequestEntityTooLarge, api.CodeTooLarge, "More than "+strconv.Itoa(h.maxItems)+" items")
+		return false
+	}
+	return true
+}
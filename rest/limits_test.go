@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+
+	for n := 0; n < 3; n++ {
+		if ok, _ := l.allow(a); !ok {
+			t.Fatalf("request %d should be within the burst", n)
+		}
+	}
+	ok, wait := l.allow(a)
+	if ok || 500*time.Millisecond != wait {
+		t.Fatalf("the bucket should be empty for 500ms, got %v %v", ok, wait)
+	}
+	if ok, _ = l.allow(b); !ok {
+		t.Fatalf("every client should have its own bucket")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ = l.allow(a); !ok {
+		t.Fatalf("a token should have been refilled")
+	}
+
+	now = now.Add(time.Hour)
+	l.allow(b)
+	if 1 != len(l.buckets) {
+		t.Fatalf("full buckets should be forgotten, got %d", len(l.buckets))
+	}
+
+	if nil != newRateLimiter(0, 10) {
+		t.Fatalf("a zero rate should disable the limiter")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	h := &Handle{mutationLimiter: newRateLimiter(1, 1)}
+	handler := h.clientIPMiddleware(h.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	send := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/operations", nil)
+		req.RemoteAddr = "192.0.2.1:1"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := send(http.MethodPost); http.StatusOK != rec.Code {
+		t.Fatalf("the first mutation should pass, got %d", rec.Code)
+	}
+	rec := send(http.MethodPost)
+	if http.StatusTooManyRequests != rec.Code || "1" != rec.Header().Get("Retry-After") {
+		t.Fatalf("the second mutation should be limited with a Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+	if rec = send(http.MethodGet); http.StatusOK != rec.Code {
+		t.Fatalf("listings have their own budget, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+// systemd socket activation passes its listeners from this fd on
+const listenFdsStart = 3
+
+// localConn is a connection accepted on a unix socket, whose peer is reported
+// as UnixPeer, which only the lists naming it let in or trust.
+type localConn struct {
+	net.Conn
+}
+
+type unixPeerAddr struct{}
+
+func (unixPeerAddr) Network() string { return "unix" }
+func (unixPeerAddr) String() string  { return UnixPeer }
+
+func (c localConn) RemoteAddr() net.Addr {
+	return unixPeerAddr{}
+}
+
+// unixListener reports the peers as UnixPeer, and removes path, the socket
+// it created, once closed
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l unixListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if nil != err {
+		return nil, err
+	}
+	return localConn{c}, nil
+}
+
+func (l unixListener) Close() error {
+	err := l.Listener.Close()
+	if "" != l.path {
+		os.Remove(l.path)
+	}
+	return err
+}
+
+func wrapUnix(l net.Listener) net.Listener {
+	if "unix" == l.Addr().Network() {
+		return unixListener{Listener: l}
+	}
+	return l
+}
+
+func validateListen(entry string) error {
+	if strings.HasPrefix(entry, api.UnixPrefix) {
+		if "" == strings.TrimPrefix(entry, api.UnixPrefix) {
+			return errors.New("Invalid listen entry " + entry + ", unix socket without path")
+		}
+		return nil
+	}
+	addr, port, err := net.SplitHostPort(entry)
+	if nil != err {
+		return errors.New("Invalid listen entry " + entry + ": " + err.Error())
+	}
+	return validateServerBind(addr, port)
+}
+
+// listenUnix creates the socket at path, replacing a stale one, with the
+// given permissions and owner (-1 keeps it). It is created in a private
+// directory and only moved to path once they are set, no client can connect
+// in between.
+func listenUnix(path string, perm os.FileMode, uid, gid int) (net.Listener, error) {
+	if info, err := os.Lstat(path); nil == err && 0 != info.Mode()&os.ModeSocket {
+		os.Remove(path)
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".remote-move-")
+	if nil != err {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if nil != err {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if 0 != perm {
+		err = os.Chmod(tmp, perm)
+	}
+	if nil == err && (-1 != uid || -1 != gid) {
+		err = os.Chown(tmp, uid, gid)
+	}
+	if nil == err {
+		err = os.Rename(tmp, path)
+	}
+	if nil != err {
+		l.Close()
+		return nil, err
+	}
+	return unixListener{Listener: l, path: path}, nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation
+// (LISTEN_PID, LISTEN_FDS), nil when not socket activated.
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if nil != err || pid != os.Getpid() {
+		return nil, nil
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if nil != err || nfds < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, nfds)
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "systemd-socket-"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if nil != err {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, errors.New("systemd socket fd " + strconv.Itoa(fd) + ": " + err.Error())
+		}
+		listeners = append(listeners, wrapUnix(l))
+	}
+	return listeners, nil
+}
+
+// Listen binds the sockets passed by systemd, or else every listen entry. It
+// is called by Serve if not done before, which allows binding privileged
+// ports before dropping root.
+func (h *Handle) Listen() error {
+	listeners, err := systemdListeners()
+	if nil != err {
+		return err
+	}
+	if nil != listeners {
+		for n, l := range listeners {
+			listeners[n] = wrapTLS(l, h.tlsConfig)
+		}
+		h.listeners = listeners
+		return nil
+	}
+
+	listeners = make([]net.Listener, 0, len(h.listen))
+	for _, entry := range h.listen {
+		var l net.Listener
+		if strings.HasPrefix(entry, api.UnixPrefix) {
+			l, err = listenUnix(strings.TrimPrefix(entry, api.UnixPrefix), h.socketPerm, h.socketUid, h.socketGid)
+		} else {
+			l, err = net.Listen("tcp", entry)
+		}
+		if nil != err {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, wrapTLS(l, h.tlsConfig))
+	}
+	h.listeners = listeners
+	return nil
+}
@@ -0,0 +1,53 @@
+package rest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+func TestUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "remote-move.sock")
+	allowed, _ := parsePrefixes([]string{"127.0.0.1", UnixPeer})
+	h := &Handle{allowedCIDRs: allowed, listen: []string{api.UnixPrefix + sock}, socketPerm: 0600, socketUid: -1, socketGid: -1}
+	if err := h.Listen(); nil != err {
+		t.Fatalf("could not listen %v", err)
+	}
+	if info, err := os.Stat(sock); nil != err || 0600 != info.Mode().Perm() {
+		t.Fatalf("the socket should be created with socketMode, got %v %v", info, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(sock)); 1 != len(entries) {
+		t.Fatalf("only the socket should be left, got %v", entries)
+	}
+	srv := &http.Server{Handler: h.clientIPMiddleware(h.ipRestrictionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	})))}
+	go srv.Serve(h.listeners[0])
+	c := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+	}}}
+	get := func() int {
+		resp, err := c.Get("http://localhost/")
+		if nil != err {
+			t.Fatalf("could not connect %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(); http.StatusOK != code {
+		t.Fatalf("unix clients should be let in by %s, got %d", UnixPeer, code)
+	}
+	h.allowedCIDRs, _ = parsePrefixes([]string{"127.0.0.1"})
+	if code := get(); http.StatusForbidden != code {
+		t.Fatalf("unix clients are not 127.0.0.1, got %d", code)
+	}
+	srv.Close()
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("the socket should be removed once closed %v", err)
+	}
+}
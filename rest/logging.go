@@ -0,0 +1,34 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logRequests logs every request once answered, rejected ones included
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		client := r.RemoteAddr
+		if addr, ok := clientIP(r); ok {
+			client = addrString(addr)
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if rec.status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "request",
+			"client", client,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+		)
+	})
+}
@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/metrics"
+)
+
+var (
+	httpRequests     = metrics.Default.NewCounterVec("remote_move_http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code")
+	httpDuration     = metrics.Default.NewHistogramVec("remote_move_http_request_duration_seconds", "HTTP request latencies by route.", metrics.DefBuckets, "route")
+	rejectedRequests = metrics.Default.NewCounterVec("remote_move_rejected_requests_total", "Requests rejected before reaching a route, by reason.", "reason")
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// instrument records the request count and latency of the route
+func instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.Since(start, route)
+	})
+}
+
+// registerCollectors exports the source listing sizes and the destination
+// free space, collected on every scrape.
+func (h *Handle) registerCollectors() {
+	metrics.Default.NewGaugeFunc("remote_move_source_items", "Items listed in each source directory.", func() []metrics.Sample {
+		mup, _ := h.filedir.GetSrcMapItems()
+		samples := make([]metrics.Sample, 0, len(mup))
+		for dir, items := range mup {
+			samples = append(samples, metrics.Sample{LabelValues: []string{dir}, Value: float64(len(items))})
+		}
+		return samples
+	}, "dir")
+	metrics.Default.NewGaugeFunc("remote_move_destination_free_bytes", "Bytes available on the filesystem of each destination root.", func() []metrics.Sample {
+		free, _ := h.filedir.GetDestFreeSpace()
+		samples := make([]metrics.Sample, 0, len(free))
+		for root, bytes := range free {
+			samples = append(samples, metrics.Sample{LabelValues: []string{root}, Value: float64(bytes)})
+		}
+		return samples
+	}, "root")
+}
+
+// handleMetrics serves the metrics to clients allowed the metrics operation,
+// as the source directories they list are not filtered by the roles
+func (h *Handle) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	if !perms.Can("metrics") {
+		rejectedRequests.Inc("permission")
+		h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Not allowed to read the metrics")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Default.WriteTo(w)
+}
@@ -0,0 +1,41 @@
+package rest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+// openAPISpec documents the API routes, rest/openapi_test.go checks it
+// against the routes and the request/response types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPISpec returns the OpenAPI 3 document of the API
+func OpenAPISpec() []byte {
+	return append([]byte(nil), openAPISpec...)
+}
+
+// openAPIFor sets the server url of the spec to the base path
+func openAPIFor(basePath string) ([]byte, error) {
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); nil != err {
+		return nil, err
+	}
+	url := basePath
+	if "" == url {
+		url = "/"
+	}
+	spec["servers"] = []map[string]string{{"url": url}}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func (h *Handle) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.openAPI)
+}
@@ -0,0 +1,254 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+	"github.com/shoaib42/remote-move/io"
+)
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Responses map[string]json.RawMessage `json:"responses"`
+		Schemas   map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Responses   map[string]json.RawMessage `json:"responses"`
+}
+
+func loadSpec(t *testing.T) openAPIDoc {
+	var doc openAPIDoc
+	if err := json.Unmarshal(OpenAPISpec(), &doc); nil != err {
+		t.Fatalf("openapi.json is not valid json %v", err)
+	}
+	return doc
+}
+
+// fakeIO is an in memory io.IOHelpers, moving "bad" or "gone" fails
+type fakeIO struct{}
+
+func (fakeIO) DoMvChown(from, what, where string) error {
+	switch what {
+	case "bad":
+		return &io.OpError{Kind: io.ErrNotFound, Msg: "item not found in source directory"}
+	case "gone":
+		return &fs.PathError{Op: "stat", Path: from + "/" + what, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+func (f fakeIO) DoCpChown(from, what, where string) error { return f.DoMvChown(from, what, where) }
+func (fakeIO) GetDestDirList() (map[string][]string, error) {
+	return map[string][]string{"media": {"movies"}}, nil
+}
+func (fakeIO) GetSrcMapItems() (map[string][]string, error) {
+	return map[string][]string{"/dl": {"bad", "good"}}, nil
+}
+func (fakeIO) GetDestFreeSpace() (map[string]uint64, error)             { return nil, nil }
+func (fakeIO) GetDestPolicy(where string) conf.Policy                   { return conf.Policy{Uid: 1, Gid: 1} }
+func (fakeIO) SetPolicies(defaults conf.Policy, policies []conf.Policy) {}
+
+func (h *Handle) routesByPath() map[string]route {
+	ret := make(map[string]route)
+	for _, rt := range h.routes() {
+		ret[rt.docPath()] = rt
+	}
+	return ret
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	h := &Handle{}
+	documented := make(map[string]bool)
+	for _, rt := range h.routes() {
+		path := rt.docPath()
+		if 0 == len(rt.methods) {
+			if _, ok := doc.Paths[path]; ok {
+				t.Fatalf("%s is not an API route but is in openapi.json", path)
+			}
+			continue
+		}
+		ops, ok := doc.Paths[path]
+		if !ok {
+			t.Fatalf("route %s is missing from openapi.json", path)
+		}
+		for _, m := range rt.methods {
+			if _, ok := ops[strings.ToLower(m)]; !ok {
+				t.Fatalf("%s %s is missing from openapi.json", m, path)
+			}
+		}
+		if len(ops) != len(rt.methods) {
+			t.Fatalf("openapi.json documents other methods than %v for %s", rt.methods, path)
+		}
+		documented[path] = true
+	}
+	for path := range doc.Paths {
+		if !documented[path] {
+			t.Fatalf("%s is in openapi.json but not routed", path)
+		}
+	}
+}
+
+func jsonFields(typ reflect.Type) []string {
+	fields := make([]string, 0, typ.NumField())
+	for n := 0; n < typ.NumField(); n++ {
+		fields = append(fields, strings.Split(typ.Field(n).Tag.Get("json"), ",")[0])
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	doc := loadSpec(t)
+	types := []reflect.Type{
+		reflect.TypeOf(api.MoveRequest{}),
+		reflect.TypeOf(api.MoveOpertationResponse{}),
+		reflect.TypeOf(api.PolicyResponse{}),
+		reflect.TypeOf(api.DataResponse{}),
+		reflect.TypeOf(api.APIErrorDetail{}),
+		reflect.TypeOf(api.APIError{}),
+		reflect.TypeOf(api.ErrorResponse{}),
+		reflect.TypeOf(api.Source{}),
+		reflect.TypeOf(api.DestinationSubdir{}),
+		reflect.TypeOf(api.Destination{}),
+		reflect.TypeOf(api.OperationRequest{}),
+		reflect.TypeOf(api.ItemResult{}),
+		reflect.TypeOf(api.Operation{}),
+		reflect.TypeOf(api.LoginRequest{}),
+		reflect.TypeOf(api.SessionResponse{}),
+		reflect.TypeOf(api.TOTPStatus{}),
+		reflect.TypeOf(api.TOTPEnrollment{}),
+		reflect.TypeOf(api.TOTPCode{}),
+		reflect.TypeOf(api.RecoveryCodes{}),
+		reflect.TypeOf(api.TokenRequest{}),
+		reflect.TypeOf(api.APIToken{}),
+	}
+	for _, typ := range types {
+		schema, ok := doc.Components.Schemas[typ.Name()]
+		if !ok {
+			t.Fatalf("schema %s is missing from openapi.json", typ.Name())
+		}
+		props := make([]string, 0, len(schema.Properties))
+		for p := range schema.Properties {
+			props = append(props, p)
+		}
+		sort.Strings(props)
+		if fields := jsonFields(typ); !reflect.DeepEqual(fields, props) {
+			t.Fatalf("schema %s has properties %v, the go type has %v", typ.Name(), props, fields)
+		}
+	}
+}
+
+// TestOpenAPIResponses checks the handlers only answer with documented status
+// codes.
+func TestOpenAPIResponses(t *testing.T) {
+	doc := loadSpec(t)
+	allowed, _ := parsePrefixes([]string{"192.0.2.1"})
+	authority, _ := auth.New(&conf.Configuration{})
+	h := &Handle{allowedCIDRs: allowed, filedir: fakeIO{}, assets: &webAssets{}, maxBodyBytes: 1024, maxItems: 2, authority: authority, sessions: newSessions(time.Hour)}
+	h.tokens, _ = auth.OpenTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	handler := h.handler()
+
+	cases := []struct {
+		method, path, remote, body string
+		expect                     int
+		doc                        string // the openapi.json path, path by default
+	}{
+		{http.MethodGet, "/data", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodPost, "/data", "192.0.2.1:1", "", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/data", "192.0.2.2:1", "", http.StatusForbidden, ""},
+		{http.MethodPost, "/move", "192.0.2.1:1", `{"src":"/dl","items":["good","bad"],"dest":"media/movies"}`, http.StatusOK, ""},
+		{http.MethodPost, "/move", "192.0.2.1:1", `{"src":`, http.StatusBadRequest, ""},
+		{http.MethodGet, "/move", "192.0.2.1:1", "", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/move", "192.0.2.1:1", "", http.StatusUnsupportedMediaType, ""},
+		{http.MethodPost, "/move", "192.0.2.1:1", `{"src":"/dl","items":["a","b","c"],"dest":"media/movies"}`, http.StatusRequestEntityTooLarge, ""},
+		{http.MethodPost, "/copy", "192.0.2.1:1", `{"src":"` + strings.Repeat("a", 1024) + `"}`, http.StatusRequestEntityTooLarge, ""},
+		{http.MethodPost, "/copy", "192.0.2.1:1", `{"src":"/dl","items":["good"],"dest":"media/movies"}`, http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/sources", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/sources", "192.0.2.2:1", "", http.StatusForbidden, ""},
+		{http.MethodGet, "/api/v1/sources/L2Rs", "192.0.2.1:1", "", http.StatusOK, "/api/v1/sources/{source}"},
+		{http.MethodGet, "/api/v1/sources/L25vcGU", "192.0.2.1:1", "", http.StatusNotFound, "/api/v1/sources/{source}"},
+		{http.MethodDelete, "/api/v1/sources/L2Rs", "192.0.2.1:1", "", http.StatusMethodNotAllowed, "/api/v1/sources/{source}"},
+		{http.MethodGet, "/api/v1/destinations", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/destinations/media", "192.0.2.1:1", "", http.StatusOK, "/api/v1/destinations/{root}"},
+		{http.MethodGet, "/api/v1/destinations/nope", "192.0.2.1:1", "", http.StatusNotFound, "/api/v1/destinations/{root}"},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", `{"operation":"move","src":"/dl","items":["good"],"dest":"media/movies"}`, http.StatusCreated, ""},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", `{"operation":"copy","src":"/dl","items":["good","gone"],"dest":"media/movies"}`, http.StatusMultiStatus, ""},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", `{"operation":"move","src":"/dl","items":["gone"],"dest":"media/movies"}`, http.StatusNotFound, ""},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", `{"operation":"link","src":"/dl","items":["good"],"dest":"media/movies"}`, http.StatusBadRequest, ""},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", `{"src":`, http.StatusBadRequest, ""},
+		{http.MethodPut, "/api/v1/operations", "192.0.2.1:1", "{}", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/api/v1/operations", "192.0.2.1:1", "", http.StatusUnsupportedMediaType, ""},
+		{http.MethodGet, "/api/v1/operations", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v1/operations/nope", "192.0.2.1:1", "", http.StatusNotFound, "/api/v1/operations/{id}"},
+		{http.MethodGet, "/api/v1/session", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodPost, "/api/v1/session", "192.0.2.1:1", `{"username":"alice","password":"secret"}`, http.StatusUnauthorized, ""},
+		{http.MethodDelete, "/api/v1/session", "192.0.2.1:1", "", http.StatusNoContent, ""},
+		{http.MethodGet, "/api/v1/session/totp", "192.0.2.1:1", "", http.StatusUnauthorized, ""},
+		{http.MethodPut, "/api/v1/session/totp", "192.0.2.1:1", `{"code":"123456"}`, http.StatusUnauthorized, ""},
+		{http.MethodPatch, "/api/v1/session/totp", "192.0.2.1:1", "", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/api/v1/tokens", "192.0.2.1:1", `{"name":"backup","expires":"2100-01-01T00:00:00Z","operations":["copy"],"sources":["/dl"],"destinations":["media"]}`, http.StatusCreated, ""},
+		{http.MethodPost, "/api/v1/tokens", "192.0.2.1:1", `{"name":"root","expires":"2100-01-01T00:00:00Z","operations":["admin"]}`, http.StatusBadRequest, ""},
+		{http.MethodGet, "/api/v1/tokens", "192.0.2.1:1", "", http.StatusOK, ""},
+		{http.MethodDelete, "/api/v1/tokens/nope", "192.0.2.1:1", "", http.StatusNotFound, "/api/v1/tokens/{id}"},
+		{http.MethodGet, "/api/v1/tokens/nope", "192.0.2.1:1", "", http.StatusMethodNotAllowed, "/api/v1/tokens/{id}"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, bytes.NewBufferString(c.body))
+		req.RemoteAddr = c.remote
+		if "" != c.body {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if c.expect != rec.Code {
+			t.Fatalf("%s %s should answer %d, got %d", c.method, c.path, c.expect, rec.Code)
+		}
+		path := c.doc
+		if "" == path {
+			path = c.path
+		}
+		op, ok := doc.Paths[path][strings.ToLower(c.method)]
+		if !ok {
+			op = doc.Paths[path][strings.ToLower(h.routesByPath()[path].methods[0])]
+		}
+		if _, ok := op.Responses[strconv.Itoa(rec.Code)]; !ok {
+			t.Fatalf("%s %s answered %d which openapi.json does not document", c.method, c.path, rec.Code)
+		}
+		if strings.HasPrefix(c.path, api.APIPrefix) {
+			if rec.Code >= 400 && "" == rec.Header().Get("Content-Type") {
+				t.Fatalf("%s %s should answer a json error", c.method, c.path)
+			}
+			continue
+		}
+		if http.StatusOK == rec.Code {
+			var data api.DataResponse
+			dec := json.NewDecoder(rec.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&data); nil != err {
+				t.Fatalf("%s %s answered an invalid DataResponse %v", c.method, c.path, err)
+			}
+			if "/move" == c.path && (1 != len(data.OpResponse) || "/dl/bad" != data.OpResponse[0].Src || api.CodeNotFound != data.OpResponse[0].Code) {
+				t.Fatalf("the failed item should be reported %v", data.OpResponse)
+			}
+		}
+	}
+}
@@ -1,79 +1,74 @@
 package rest
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
+	"io/fs"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
 
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
 	"github.com/shoaib42/remote-move/io"
+	"github.com/shoaib42/remote-move/webhook"
 )
 
-var indexContent []byte
-
 type RemoteMoveREST interface {
-	Serve()
+	Listen() error
+	Serve() error
+	Shutdown(ctx context.Context) error
+	clientIPMiddleware(next http.Handler) http.Handler
 	ipRestrictionMiddleware(next http.Handler) http.Handler
+	securityMiddleware(next http.Handler) http.Handler
+	identityMiddleware(next http.Handler) http.Handler
 	handleData(w http.ResponseWriter, r *http.Request)
 	handleMove(w http.ResponseWriter, r *http.Request)
 	handleCopy(w http.ResponseWriter, r *http.Request)
 }
 
 type Handle struct {
-	allowedCIDRs   []string
-	serverBindAddr string
-	serverBindPort string
-	filedir        io.IOHelpers
-}
-
-type MoveOpertationResponse struct {
-	Operation string `json:"operation"`
-	Src       string `json:"source"`
-	Dest      string `json:"destination"`
-	Message   string `json:"message"`
-}
-
-type DataResponse struct {
-	OpResponse           []MoveOpertationResponse `json:"opResponse"`
-	ListingErrors        bool                     `json:"listingErrors"`
-	SrcDirAndItsContents map[string][]string      `json:"srcDirAndItsContents"`
-	Destination          []string                 `json:"destination"`
-}
-
-type MoveRequest struct {
-	Src   string   `json:"src"`
-	Items []string `json:"items"`
-	Dest  string   `json:"dest"`
-}
-
-func validateIPCIDR(allowedCIDRs []string) ([]string, error) {
-
-	okCIDRs := make([]string, 0)
-
-	for _, ipOrCIDR := range allowedCIDRs {
-		ip := net.ParseIP(ipOrCIDR)
-		if ip != nil {
-			cidr := &net.IPNet{
-				IP:   ip,
-				Mask: net.CIDRMask(32, 32),
-			}
-			okCIDRs = append(okCIDRs, cidr.String())
-		} else {
-			_, _, err := net.ParseCIDR(ipOrCIDR)
-			if err == nil {
-				okCIDRs = append(okCIDRs, ipOrCIDR)
-			}
-		}
-	}
-	if 0 == len(okCIDRs) {
-		return nil, errors.New("No valid CIDRs or ip provided")
-	}
-	return okCIDRs, nil
+	allowedCIDRs      []netip.Prefix
+	deniedCIDRs       []netip.Prefix
+	trustedProxies    []netip.Prefix
+	allowedOrigins    map[string]bool
+	listen            []string
+	socketPerm        os.FileMode
+	socketUid         int
+	socketGid         int
+	basePath          string
+	assets            *webAssets
+	filedir           io.IOHelpers
+	listeners         []net.Listener
+	server            *http.Server
+	openAPI           []byte
+	operations        operations
+	limits            conf.Limits
+	maxBodyBytes      int64
+	maxItems          int
+	listingLimiter    *rateLimiter
+	mutationLimiter   *rateLimiter
+	authority         *auth.Authority
+	sessions          *sessions
+	totp              *auth.TOTPStore
+	tokens            *auth.TokenStore
+	tlsConfig         *tls.Config
+	clientCerts       []conf.ClientCert
+	certsBypassCIDRs  bool
+	forwardAuth       *forwardAuth
+	webhooks          *webhook.Dispatcher
+	totpIssuer        string
+	lanCIDRs          []netip.Prefix
+	requireOutsideLAN bool
 }
 
 func validateServerBind(bindAddr string, bindPort string) error {
@@ -94,81 +89,257 @@ func validateServerBind(bindAddr string, bindPort string) error {
 	return nil
 }
 
-func New(indexFilePath, bindArr, port string, allowedCIDRs []string, ioHelpers io.IOHelpers) (RemoteMoveREST, error) {
-	file, err := os.Open(indexFilePath)
-	if err != nil {
-		return nil, err
+// normalizeBasePath gives "" for the root, else the path with a leading and
+// without a trailing /, ex: remote-move/ -> /remote-move
+func normalizeBasePath(basePath string) (string, error) {
+	basePath = strings.Trim(basePath, "/")
+	if "" == basePath {
+		return "", nil
 	}
-	defer file.Close()
+	basePath = "/" + basePath
+	if path.Clean(basePath) != basePath || strings.ContainsAny(basePath, "?#") {
+		return "", errors.New("Invalid base path " + basePath)
+	}
+	return basePath, nil
+}
 
-	indexContent, err = ioutil.ReadAll(file)
+// New serves index.html and static/ from assets, ex: the embedded ones or an
+// os.DirFS of a customized copy, as set up by the configuration c.
+func New(assets fs.FS, c *conf.Configuration, ioHelpers io.IOHelpers) (RemoteMoveREST, error) {
+	basePath, err := normalizeBasePath(c.BasePath)
+	if nil != err {
+		return nil, err
+	}
+	webAssets, err := loadAssets(assets, basePath)
 	if err != nil {
 		return nil, err
 	}
-
-	if err = validateServerBind(bindArr, port); nil != err {
+	openAPI, err := openAPIFor(basePath)
+	if nil != err {
 		return nil, err
 	}
 
-	okCIDRs, err := validateIPCIDR(allowedCIDRs)
+	listen := c.Listen
+	if 0 == len(listen) {
+		listen = []string{net.JoinHostPort(c.ServerBindAddr, c.ServerBindPort)}
+	}
+	for _, entry := range listen {
+		if err = validateListen(entry); nil != err {
+			return nil, err
+		}
+	}
 
+	allowed, err := parsePrefixes(c.AllowedCIDRs)
 	if nil != err {
 		return nil, err
 	}
+	if 0 == len(allowed) {
+		return nil, errors.New("No valid CIDRs or ip provided")
+	}
+	denied, err := parsePrefixes(c.DeniedCIDRs)
+	if nil != err {
+		return nil, err
+	}
+	trusted, err := parsePrefixes(c.TrustedProxies)
+	if nil != err {
+		return nil, err
+	}
+	origins, err := parseOrigins(c.AllowedOrigins)
+	if nil != err {
+		return nil, err
+	}
+	lan, err := parsePrefixes(c.TwoFactor.LANCIDRs)
+	if nil != err {
+		return nil, err
+	}
+	tlsConfig, err := loadTLS(c.TLS)
+	if nil != err {
+		return nil, err
+	}
+	forward, err := newForwardAuth(c.ForwardAuth, c.TrustedProxies)
+	if nil != err {
+		return nil, err
+	}
+	webhooks, err := webhook.New(c.Webhooks)
+	if nil != err {
+		return nil, err
+	}
+	authority, err := auth.New(c)
+	if nil != err {
+		return nil, err
+	}
+	tokens, err := auth.OpenTokenStore(filepath.Join(c.StateDir, "tokens.json"))
+	if nil != err {
+		return nil, err
+	}
+	var totp *auth.TOTPStore
+	if authority.HasUsers() {
+		if totp, err = auth.OpenTOTPStore(filepath.Join(c.StateDir, "totp.json")); nil != err {
+			return nil, err
+		}
+	}
 
-	return &Handle{
-		allowedCIDRs:   okCIDRs,
-		serverBindAddr: bindArr,
-		serverBindPort: port,
-		filedir:        ioHelpers,
-	}, nil
+	h := &Handle{
+		allowedCIDRs:      allowed,
+		deniedCIDRs:       denied,
+		trustedProxies:    trusted,
+		allowedOrigins:    origins,
+		listen:            listen,
+		socketPerm:        c.SocketPerm,
+		socketUid:         c.SocketUid,
+		socketGid:         c.SocketGid,
+		basePath:          basePath,
+		assets:            webAssets,
+		openAPI:           openAPI,
+		filedir:           ioHelpers,
+		limits:            c.Limits,
+		maxBodyBytes:      c.Limits.MaxBodyBytes,
+		maxItems:          c.Limits.MaxItems,
+		listingLimiter:    newRateLimiter(c.Limits.ListingRate, c.Limits.ListingBurst),
+		mutationLimiter:   newRateLimiter(c.Limits.MutationRate, c.Limits.MutationBurst),
+		authority:         authority,
+		sessions:          newSessions(c.SessionTTL),
+		totp:              totp,
+		tokens:            tokens,
+		tlsConfig:         tlsConfig,
+		clientCerts:       c.TLS.ClientCerts,
+		certsBypassCIDRs:  c.TLS.ClientCertsBypassCIDRs,
+		forwardAuth:       forward,
+		webhooks:          webhooks,
+		totpIssuer:        c.TwoFactor.Issuer,
+		lanCIDRs:          lan,
+		requireOutsideLAN: c.TwoFactor.RequireOutsideLAN,
+	}
+	h.server = &http.Server{
+		Handler:           h.handler(),
+		ReadHeaderTimeout: h.limits.ReadHeaderTimeout,
+		ReadTimeout:       h.limits.ReadTimeout,
+		WriteTimeout:      h.limits.WriteTimeout,
+		IdleTimeout:       h.limits.IdleTimeout,
+	}
+	h.registerCollectors()
+	return h, nil
 }
 
-func (h *Handle) ipRestrictionMiddleware(next http.Handler) http.Handler {
-
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
-		requestIP := net.ParseIP(remoteIP)
-		isIPAllowed := false
-		for _, subnet := range h.allowedCIDRs {
-			_, subnetIPNet, err := net.ParseCIDR(subnet)
-			if nil == err && subnetIPNet.Contains(requestIP) {
-				isIPAllowed = true
-				break
-			}
-		}
+// route is a mux pattern under the base path, methods lists the methods of
+// the API routes, documented in openapi.json under doc (path by default), and
+// is empty for everything else.
+type route struct {
+	path    string
+	methods []string
+	handler http.HandlerFunc
+	doc     string
+}
 
-		if err != nil || !isIPAllowed {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
+func (rt route) docPath() string {
+	if "" != rt.doc {
+		return rt.doc
+	}
+	return rt.path
+}
 
-		next.ServeHTTP(w, r)
-	})
+func (h *Handle) routes() []route {
+	return []route{
+		{"/", nil, h.assets.serveIndex, ""},
+		{"/static/", nil, h.assets.serveStatic, ""},
+		{"/metrics", nil, h.handleMetrics, ""},
+		{"/openapi.json", nil, h.handleOpenAPI, ""},
+		{"/data", []string{http.MethodGet}, h.handleData, ""},
+		{"/move", []string{http.MethodPost}, h.handleMove, ""},
+		{"/copy", []string{http.MethodPost}, h.handleCopy, ""},
+		{api.APIPrefix + "/", nil, h.handleAPINotFound, ""},
+		{api.APIPrefix + "/session", []string{http.MethodGet, http.MethodPost, http.MethodDelete}, h.handleSession, ""},
+		{api.APIPrefix + "/session/totp", []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}, h.handleTOTP, ""},
+		{api.APIPrefix + "/sources", []string{http.MethodGet}, h.handleSources, ""},
+		{api.APIPrefix + "/sources/", []string{http.MethodGet}, h.handleSource, api.APIPrefix + "/sources/{source}"},
+		{api.APIPrefix + "/destinations", []string{http.MethodGet}, h.handleDestinations, ""},
+		{api.APIPrefix + "/destinations/", []string{http.MethodGet}, h.handleDestination, api.APIPrefix + "/destinations/{root}"},
+		{api.APIPrefix + "/operations", []string{http.MethodGet, http.MethodPost}, h.handleOperations, ""},
+		{api.APIPrefix + "/operations/", []string{http.MethodGet}, h.handleOperation, api.APIPrefix + "/operations/{id}"},
+		{api.APIPrefix + "/tokens", []string{http.MethodGet, http.MethodPost}, h.handleTokens, ""},
+		{api.APIPrefix + "/tokens/", []string{http.MethodDelete}, h.handleToken, api.APIPrefix + "/tokens/{id}"},
+		{api.APIPrefix + "/webhooks/deliveries", []string{http.MethodGet}, h.handleDeliveries, ""},
+	}
 }
 
-func (h *Handle) Serve() {
-	staticHandler := http.StripPrefix("/static/", http.FileServer(http.Dir("static")))
+func (h *Handle) newMux() *http.ServeMux {
 	restrictedMux := http.NewServeMux()
-	restrictedMux.HandleFunc("/", handleIndex)
-	restrictedMux.HandleFunc("/move", h.handleMove)
-	restrictedMux.HandleFunc("/copy", h.handleCopy)
-	restrictedMux.HandleFunc("/data", h.handleData)
-	restrictedMux.Handle("/static/", staticHandler)
+	for _, rt := range h.routes() {
+		var handler http.Handler = rt.handler
+		if 0 != len(rt.methods) {
+			handler = h.rateLimit(h.requireJSON(rt.methods, handler))
+		}
+		restrictedMux.Handle(h.basePath+rt.path, instrument(rt.path, handler))
+	}
+	if "" != h.basePath {
+		restrictedMux.Handle(h.basePath, http.RedirectHandler(h.basePath+"/", http.StatusMovedPermanently))
+	}
+	return restrictedMux
+}
 
-	server := &http.Server{
-		Addr:    h.serverBindAddr + ":" + h.serverBindPort,
-		Handler: h.ipRestrictionMiddleware(restrictedMux),
+// handler is the mux behind the middlewares
+func (h *Handle) handler() http.Handler {
+	return h.clientIPMiddleware(logRequests(h.securityMiddleware(h.ipRestrictionMiddleware(h.identityMiddleware(h.newMux())))))
+}
+
+// Serve serves on the listeners until one fails, or http.ErrServerClosed
+// once Shutdown is called
+func (h *Handle) Serve() error {
+	if nil == h.listeners {
+		if err := h.Listen(); nil != err {
+			return err
+		}
+	}
+	errs := make(chan error, len(h.listeners))
+	for _, l := range h.listeners {
+		slog.Info("serving", "network", l.Addr().Network(), "addr", l.Addr().String())
+		go func(l net.Listener) {
+			errs <- h.server.Serve(l)
+		}(l)
+	}
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		h.server.Close()
+	}
+	return err
+}
+
+// Shutdown stops accepting clients and waits, until ctx is done, for the
+// requests in progress and then for the webhook deliveries
+func (h *Handle) Shutdown(ctx context.Context) error {
+	return errors.Join(h.server.Shutdown(ctx), h.webhooks.Shutdown(ctx))
+}
+
+// readable keeps the source directories perms can read
+func readable(perms *auth.Permissions, mup map[string][]string) map[string][]string {
+	ret := make(map[string][]string, len(mup))
+	for src, items := range mup {
+		if perms.CanRead(src) {
+			ret[src] = items
+		}
 	}
-	server.ListenAndServe()
+	return ret
 }
 
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	http.ServeContent(w, r, "index.html", time.Now(), bytes.NewReader(indexContent))
+// writable keeps the root/subdir destinations perms can write to, and the
+// roots having some
+func writable(perms *auth.Permissions, ddir map[string][]string) map[string][]string {
+	ret := make(map[string][]string, len(ddir))
+	for root, subdirs := range ddir {
+		keep := make([]string, 0, len(subdirs))
+		for _, d := range subdirs {
+			if perms.CanWrite(root + "/" + d) {
+				keep = append(keep, d)
+			}
+		}
+		if 0 != len(keep) {
+			ret[root] = keep
+		}
+	}
+	return ret
 }
 
-func (h *Handle) responseData(w http.ResponseWriter, mor []MoveOpertationResponse) {
+func (h *Handle) responseData(w http.ResponseWriter, r *http.Request, perms *auth.Permissions, mor []api.MoveOpertationResponse) {
 	mup, err := h.filedir.GetSrcMapItems()
 	listingErrors := false
 	if nil != err {
@@ -179,14 +350,28 @@ func (h *Handle) responseData(w http.ResponseWriter, mor []MoveOpertationRespons
 	ddir, err := h.filedir.GetDestDirList()
 
 	if nil != err {
-		ddir = make([]string, 0)
+		ddir = make(map[string][]string)
 		listingErrors = true
 	}
-	data := DataResponse{
+	mup, ddir = readable(perms, mup), writable(perms, ddir)
+	policies := make(map[string]api.PolicyResponse)
+	for root, subdirs := range ddir {
+		for _, d := range subdirs {
+			where := root + "/" + d
+			policies[where] = toPolicyResponse(h.filedir.GetDestPolicy(where))
+		}
+	}
+
+	id, _ := identity(r)
+	data := api.DataResponse{
+		User:                 id.Name,
+		Via:                  id.Via,
+		Operations:           perms.Operations(),
 		OpResponse:           mor,
 		ListingErrors:        listingErrors,
 		SrcDirAndItsContents: mup,
-		Destination:          ddir,
+		Destinations:         ddir,
+		DestinationPolicies:  policies,
 	}
 
 	err = json.NewEncoder(w).Encode(data)
@@ -196,14 +381,68 @@ func (h *Handle) responseData(w http.ResponseWriter, mor []MoveOpertationRespons
 
 }
 
+func toPolicyResponse(p conf.Policy) api.PolicyResponse {
+	pr := api.PolicyResponse{
+		Match:      p.Match,
+		Owner:      strconv.Itoa(p.Uid) + ":" + strconv.Itoa(p.Gid),
+		FileMode:   "unchanged",
+		DirMode:    "unchanged",
+		SetgidDirs: p.Setgid(),
+	}
+	if p.InheritOwner {
+		pr.Owner = conf.OwnerInherit
+	}
+	if p.InheritFileMode {
+		pr.FileMode = conf.ModeInherit
+	} else if 0 != p.FilePerm {
+		pr.FileMode = "0" + strconv.FormatUint(uint64(p.FilePerm), 8)
+	}
+	if p.InheritDirMode {
+		pr.DirMode = conf.ModeInherit
+	} else if 0 != p.DirPerm {
+		pr.DirMode = "0" + strconv.FormatUint(uint64(p.DirPerm), 8)
+	}
+	return pr
+}
+
 func (h *Handle) handleData(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
 	w.Header().Set("Allow", "GET")
 	w.Header().Set("Content-Type", "application/json")
-	h.responseData(w, nil)
+	h.responseData(w, r, perms, nil)
+}
+
+// opResponses reports a failed item, or when only applying the ownership and
+// permissions failed, every path that could not be chowned/chmoded.
+func opResponses(op, src, dest string, err error) []api.MoveOpertationResponse {
+	var pe *io.PolicyError
+	if !errors.As(err, &pe) {
+		return []api.MoveOpertationResponse{{
+			Src:       src,
+			Dest:      dest,
+			Operation: op,
+			Message:   err.Error(),
+			Code:      ErrorCode(err),
+		}}
+	}
+	mor := make([]api.MoveOpertationResponse, 0, len(pe.Failures))
+	for _, f := range pe.Failures {
+		mor = append(mor, api.MoveOpertationResponse{
+			Src:       src,
+			Dest:      f.Path,
+			Operation: f.Op,
+			Message:   f.Err.Error(),
+			Code:      api.CodeOwnershipFailed,
+		})
+	}
+	return mor
 }
 
 func (h *Handle) handleMove(w http.ResponseWriter, r *http.Request) {
@@ -212,28 +451,36 @@ func (h *Handle) handleMove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var moveRequest MoveRequest
-	err := json.NewDecoder(r.Body).Decode(&moveRequest)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	var moveRequest api.MoveRequest
+	if !h.decodeBody(w, r, &moveRequest) || !h.checkItems(w, r, moveRequest.Items) {
+		return
+	}
+	if 0 == len(moveRequest.Items) {
+		h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "no items to move")
+		return
+	}
+	if !h.authorize(w, r, perms, "move", moveRequest.Src, moveRequest.Dest) {
 		return
 	}
 
-	mor := make([]MoveOpertationResponse, 0)
+	mor := make([]api.MoveOpertationResponse, 0)
+	op := h.newOperation(r, "move", moveRequest.Src, moveRequest.Dest)
 	for _, i := range moveRequest.Items {
-		if err = h.filedir.DoMvChown(moveRequest.Src, i, moveRequest.Dest); nil != err {
-			mor = append(mor, MoveOpertationResponse{
-				Src:       moveRequest.Src + "/" + i,
-				Dest:      moveRequest.Dest,
-				Operation: "move",
-				Message:   err.Error(),
-			})
+		err := h.filedir.DoMvChown(moveRequest.Src, i, moveRequest.Dest)
+		if nil != err {
+			mor = append(mor, opResponses("move", moveRequest.Src+"/"+i, moveRequest.Dest, err)...)
 		}
+		op.Results = append(op.Results, itemResult(i, err))
 	}
+	h.recordOperation(r, op)
 	w.Header().Set("Allow", "POST")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.responseData(w, mor)
+	h.responseData(w, r, perms, mor)
 }
 
 func (h *Handle) handleCopy(w http.ResponseWriter, r *http.Request) {
@@ -242,26 +489,34 @@ func (h *Handle) handleCopy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var moveRequest MoveRequest
-	err := json.NewDecoder(r.Body).Decode(&moveRequest)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return
+	}
+	var moveRequest api.MoveRequest
+	if !h.decodeBody(w, r, &moveRequest) || !h.checkItems(w, r, moveRequest.Items) {
+		return
+	}
+	if 0 == len(moveRequest.Items) {
+		h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "no items to copy")
+		return
+	}
+	if !h.authorize(w, r, perms, "copy", moveRequest.Src, moveRequest.Dest) {
 		return
 	}
 
-	mor := make([]MoveOpertationResponse, 0)
+	mor := make([]api.MoveOpertationResponse, 0)
+	op := h.newOperation(r, "copy", moveRequest.Src, moveRequest.Dest)
 	for _, i := range moveRequest.Items {
-		if err = h.filedir.DoCpChown(moveRequest.Src, i, moveRequest.Dest); nil != err {
-			mor = append(mor, MoveOpertationResponse{
-				Src:       moveRequest.Src + "/" + i,
-				Dest:      moveRequest.Dest,
-				Operation: "copy",
-				Message:   err.Error(),
-			})
+		err := h.filedir.DoCpChown(moveRequest.Src, i, moveRequest.Dest)
+		if nil != err {
+			mor = append(mor, opResponses("copy", moveRequest.Src+"/"+i, moveRequest.Dest, err)...)
 		}
+		op.Results = append(op.Results, itemResult(i, err))
 	}
+	h.recordOperation(r, op)
 	w.Header().Set("Allow", "POST")
 	w.Header().Set("Content-Type", "application/json")
 
-	h.responseData(w, mor)
+	h.responseData(w, r, perms, mor)
 }
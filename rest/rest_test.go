@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// newTestHandle is the Handle New makes of c, with fakeIO, minimal assets and
+// its state in a temporary directory. Clients are only allowed from
+// 192.0.2.1 unless c sets allowedCIDRs.
+func newTestHandle(t *testing.T, c *conf.Configuration) *Handle {
+	if 0 == len(c.Listen) {
+		c.Listen = []string{"127.0.0.1:8080"}
+	}
+	if 0 == len(c.AllowedCIDRs) {
+		c.AllowedCIDRs = []string{"192.0.2.1"}
+	}
+	if "" == c.StateDir {
+		c.StateDir = t.TempDir()
+	}
+	if 0 == c.SessionTTL {
+		c.SessionTTL = time.Hour
+	}
+	assets := fstest.MapFS{
+		"index.html":    {Data: []byte(`<script src="{{asset "static/app.js"}}"></script>`)},
+		"static/app.js": {Data: []byte("console.log(1)")},
+	}
+	h, err := New(assets, c, fakeIO{})
+	if nil != err {
+		t.Fatalf("could not create the handle %v", err)
+	}
+	return h.(*Handle)
+}
+
+// sendRequest serves a request from remote, a JSON one when body is not
+// empty, with the cookie and header the UI sends when session is not nil
+func sendRequest(handler http.Handler, method, path, remote, body string, session *http.Cookie, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.RemoteAddr = remote
+	if "" != body {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if nil != session {
+		req.AddCookie(session)
+		req.AddCookie(&http.Cookie{Name: api.CSRFCookie, Value: "token"})
+		req.Header.Set(api.CSRFHeader, "token")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// sessionCookie is the session cookie a login answered, nil without one
+func sessionCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rec.Result().Cookies() {
+		if api.SessionCookie == c.Name {
+			return c
+		}
+	}
+	return nil
+}
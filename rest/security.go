@@ -0,0 +1,154 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+// contentSecurityPolicy allows nothing but the ui's own scripts, styles and
+// api, and forbids framing
+const contentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self' data:; connect-src 'self'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+// parseOrigins parses the allowedOrigins, scheme://host[:port]
+func parseOrigins(origins []string) (map[string]bool, error) {
+	ret := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		u, err := url.Parse(o)
+		if nil != err || "" == u.Scheme || "" == u.Host || ("" != u.Path && "/" != u.Path) {
+			return nil, errors.New("Invalid origin " + o + ", should be scheme://host[:port]")
+		}
+		ret[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+	return ret, nil
+}
+
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// secureRequest reports if the client reached us over https, directly or
+// through a trusted proxy
+func (h *Handle) secureRequest(r *http.Request) bool {
+	if nil != r.TLS {
+		return true
+	}
+	peer, ok := peerAddr(r)
+	return ok && containsAddr(h.trustedProxies, peer) && strings.EqualFold("https", r.Header.Get("X-Forwarded-Proto"))
+}
+
+// sameOrigin reports if origin is the host the request was sent to, or one
+// of the allowedOrigins
+func (h *Handle) sameOrigin(r *http.Request, origin string) bool {
+	if h.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return nil == err && "" != u.Host && strings.EqualFold(u.Host, r.Host)
+}
+
+// fromBrowser reports if a browser sent the request, which is the only
+// client that sends cookies and the fetch metadata on its own
+func fromBrowser(r *http.Request) bool {
+	return "" != r.Header.Get("Sec-Fetch-Site") || "" != r.Header.Get("Origin") || 0 != len(r.Cookies())
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkCSRF refuses cross site mutations: the fetch metadata and origin, when
+// sent, must be same origin, and browsers must send back the token of the
+// CSRF cookie. Scripts and the cli, without cookies, only need the origin
+// checks.
+func (h *Handle) checkCSRF(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); "" != site && "same-origin" != site {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); "" != origin && !h.sameOrigin(r, origin) {
+		return false
+	}
+	if !fromBrowser(r) {
+		return true
+	}
+	cookie, err := r.Cookie(api.CSRFCookie)
+	token := r.Header.Get(api.CSRFHeader)
+	return nil == err && "" != token && 1 == subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token))
+}
+
+// isJSON reports if the request body is declared as json
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return nil == err && ("application/json" == mediaType || strings.HasSuffix(mediaType, "+json"))
+}
+
+// setCookie sets a cookie scoped to the base path, SameSite=Strict and
+// Secure over https
+func (h *Handle) setCookie(w http.ResponseWriter, r *http.Request, c *http.Cookie) {
+	c.Path = h.basePath + "/"
+	c.SameSite = http.SameSiteStrictMode
+	c.Secure = h.secureRequest(r)
+	http.SetCookie(w, c)
+}
+
+// requireJSON refuses the bodies of the methods of a route that are not
+// declared as json
+func (h *Handle) requireJSON(methods []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodPost == r.Method || http.MethodPut == r.Method || http.MethodPatch == r.Method {
+			for _, m := range methods {
+				if m == r.Method && !isJSON(r) {
+					h.writeError(w, r, http.StatusUnsupportedMediaType, api.CodeUnsupportedMedia, "Content-Type should be application/json")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// securityMiddleware sets the security headers of every answer, hands out
+// the CSRF cookie and refuses cross site mutations
+func (h *Handle) securityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "same-origin")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		header.Set("Cross-Origin-Resource-Policy", "same-origin")
+		header.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		if h.secureRequest(r) {
+			header.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+
+		if !isUnsafeMethod(r.Method) {
+			if _, err := r.Cookie(api.CSRFCookie); nil != err && !isAPIRequest(r, h.basePath) {
+				// readable by the ui, which sends it back in the CSRFHeader
+				h.setCookie(w, r, &http.Cookie{Name: api.CSRFCookie, Value: newCSRFToken()})
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !h.checkCSRF(r) {
+			rejectedRequests.Inc("csrf")
+			h.writeError(w, r, http.StatusForbidden, api.CodeCSRF, "Cross site request refused")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shoaib42/remote-move/api"
+)
+
+func TestSecurityMiddleware(t *testing.T) {
+	origins, _ := parseOrigins([]string{"https://home.lan"})
+	h := &Handle{allowedOrigins: origins}
+	handler := h.securityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://server:8089/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if "DENY" != rec.Header().Get("X-Frame-Options") || "" == rec.Header().Get("Content-Security-Policy") {
+		t.Fatalf("security headers are missing %v", rec.Header())
+	}
+	cookies := rec.Result().Cookies()
+	if 1 != len(cookies) || api.CSRFCookie != cookies[0].Name || http.SameSiteStrictMode != cookies[0].SameSite {
+		t.Fatalf("a SameSite=Strict CSRF cookie should be set, got %v", cookies)
+	}
+	token := cookies[0].Value
+
+	check := func(headers map[string]string, cookie bool, expect int) {
+		req := httptest.NewRequest(http.MethodPost, "http://server:8089/move", strings.NewReader("{}"))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		if cookie {
+			req.AddCookie(&http.Cookie{Name: api.CSRFCookie, Value: token})
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if expect != rec.Code {
+			t.Fatalf("POST with %v, cookie %v, should answer %d, got %d", headers, cookie, expect, rec.Code)
+		}
+	}
+	// scripts send neither cookies nor fetch metadata
+	check(nil, false, http.StatusOK)
+	// the ui
+	check(map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://server:8089", api.CSRFHeader: token}, true, http.StatusOK)
+	check(map[string]string{"Origin": "https://home.lan", api.CSRFHeader: token}, true, http.StatusOK)
+	// a browser without the token
+	check(map[string]string{"Sec-Fetch-Site": "same-origin"}, true, http.StatusForbidden)
+	check(map[string]string{"Sec-Fetch-Site": "same-origin", api.CSRFHeader: "forged"}, true, http.StatusForbidden)
+	// another site
+	check(map[string]string{"Sec-Fetch-Site": "cross-site", api.CSRFHeader: token}, true, http.StatusForbidden)
+	check(map[string]string{"Origin": "http://evil.lan", api.CSRFHeader: token}, true, http.StatusForbidden)
+	check(map[string]string{"Origin": "null"}, false, http.StatusForbidden)
+
+	if _, err := parseOrigins([]string{"home.lan"}); nil == err {
+		t.Fatalf("an origin without a scheme should be refused")
+	}
+}
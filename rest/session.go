@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+)
+
+// session is a logged in user, secondFactor when it gave a TOTP code and
+// enrolling the secret of an enrollment not yet confirmed
+type session struct {
+	identity     *auth.Identity
+	expires      time.Time
+	secondFactor bool
+	enrolling    string
+}
+
+// sessions are kept in memory, a restart logs everybody out
+type sessions struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	m   map[string]*session
+}
+
+func newSessions(ttl time.Duration) *sessions {
+	return &sessions{ttl: ttl, m: make(map[string]*session)}
+}
+
+func (s *sessions) create(id *auth.Identity, secondFactor bool) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for token, ss := range s.m {
+		if now.After(ss.expires) {
+			delete(s.m, token)
+		}
+	}
+	token := newCSRFToken()
+	s.m[token] = &session{identity: id, expires: now.Add(s.ttl), secondFactor: secondFactor}
+	return token
+}
+
+func (s *sessions) get(token string) (session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.m[token]
+	if !ok {
+		return session{}, false
+	}
+	if time.Now().After(ss.expires) {
+		delete(s.m, token)
+		return session{}, false
+	}
+	return *ss, true
+}
+
+// update changes the session of token, if it still exists
+func (s *sessions) update(token string, f func(*session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ss, ok := s.m[token]; ok {
+		f(ss)
+	}
+}
+
+func (s *sessions) delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, token)
+}
+
+// identity returns who the identityMiddleware found the client to be
+func identity(r *http.Request) (*auth.Identity, bool) {
+	id, ok := r.Context().Value(identityKey).(*auth.Identity)
+	return id, ok && nil != id
+}
+
+// currentSession is the session of the cookie of r
+func (h *Handle) currentSession(r *http.Request) (string, session, bool) {
+	cookie, err := r.Cookie(api.SessionCookie)
+	if nil != err {
+		return "", session{}, false
+	}
+	ss, ok := h.sessions.get(cookie.Value)
+	return cookie.Value, ss, ok
+}
+
+// sessionIdentity is the user of the session cookie, with its current roles.
+// Sessions opened without a second factor are ignored where it is required,
+// and once the user enrolled one.
+func (h *Handle) sessionIdentity(r *http.Request) *auth.Identity {
+	token, ss, ok := h.currentSession(r)
+	if !ok || (!ss.secondFactor && (h.secondFactorRequired(r) || h.enrolled(ss.identity.Name))) {
+		return nil
+	}
+	roles, ok := h.authority.Roles(ss.identity.Name)
+	if !ok {
+		h.sessions.delete(token)
+		return nil
+	}
+	return &auth.Identity{Name: ss.identity.Name, Roles: roles, Via: ss.identity.Via}
+}
+
+// identityMiddleware finds who the client is, an API token, the user of the
+// authenticating proxy, a logged in user, a client certificate or else an
+// anonymous client if they are allowed, for the handlers to check their
+// permissions. Identity headers from a peer that is not the proxy are refused
+// whatever else the client sends.
+func (h *Handle) identityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied, ok := h.proxyIdentity(w, r)
+		if !ok {
+			return
+		}
+		id, ok := h.tokenIdentity(w, r)
+		if !ok {
+			return
+		}
+		if nil == id {
+			id = proxied
+		}
+		if nil == id {
+			id = h.sessionIdentity(r)
+		}
+		if nil == id {
+			id = h.certIdentity(r)
+		}
+		if nil == id && !h.secondFactorRequired(r) {
+			id = h.authority.Anonymous()
+		}
+		if nil != id {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// permissions returns what the client may do, answering 401 when it has to
+// log in first
+func (h *Handle) permissions(w http.ResponseWriter, r *http.Request) (*auth.Permissions, bool) {
+	id, ok := identity(r)
+	if !ok {
+		h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Log in first")
+		return nil, false
+	}
+	return h.authority.Permissions(id), true
+}
+
+// authorize answers 403 unless the operation from src to dest is allowed
+func (h *Handle) authorize(w http.ResponseWriter, r *http.Request, perms *auth.Permissions, op, src, dest string) bool {
+	msg := ""
+	switch {
+	case !perms.Can(op):
+		msg = "Not allowed to " + op
+	case !perms.CanRead(src):
+		msg = "Not allowed to " + op + " from " + src
+	case !perms.CanWrite(dest):
+		msg = "Not allowed to " + op + " to " + dest
+	}
+	if "" == msg {
+		return true
+	}
+	rejectedRequests.Inc("permission")
+	h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, msg)
+	return false
+}
+
+func (h *Handle) sessionResponse(w http.ResponseWriter, id *auth.Identity) {
+	writeJSON(w, http.StatusOK, api.SessionResponse{
+		User:       id.Name,
+		Roles:      id.Roles,
+		Operations: h.authority.Permissions(id).Operations(),
+		Via:        id.Via,
+	})
+}
+
+func (h *Handle) handleSession(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		id, ok := identity(r)
+		if !ok {
+			h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Not logged in")
+			return
+		}
+		h.sessionResponse(w, id)
+	case http.MethodPost:
+		h.login(w, r)
+	case http.MethodDelete:
+		if cookie, err := r.Cookie(api.SessionCookie); nil == err {
+			h.sessions.delete(cookie.Value)
+		}
+		h.setCookie(w, r, &http.Cookie{Name: api.SessionCookie, Value: "", MaxAge: -1, HttpOnly: true})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		h.methodNotAllowed(w, r, "GET, POST, DELETE")
+	}
+}
+
+func (h *Handle) login(w http.ResponseWriter, r *http.Request) {
+	var req api.LoginRequest
+	if !h.decodeBody(w, r, &req) {
+		return
+	}
+	id, ok := h.authority.Login(req.Username, req.Password)
+	if !ok {
+		rejectedRequests.Inc("login")
+		client, _ := clientIP(r)
+		slog.Warn("login failed", "user", req.Username, "client", addrString(client))
+		h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Invalid user or password")
+		return
+	}
+	secondFactor, ok := h.checkSecondFactor(w, r, id.Name, req.Code)
+	if !ok {
+		return
+	}
+	token := h.sessions.create(id, secondFactor)
+	h.setCookie(w, r, &http.Cookie{Name: api.SessionCookie, Value: token, MaxAge: int(h.sessions.ttl.Seconds()), HttpOnly: true})
+	slog.Info("logged in", "user", id.Name)
+	h.sessionResponse(w, id)
+}
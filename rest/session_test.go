@@ -0,0 +1,162 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestSessionsAndRoles(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+	handler := newTestHandle(t, &conf.Configuration{
+		Roles: []conf.Role{{Name: "mover", Sources: []string{"/dl"}, Destinations: []string{"media/movies"}, Operations: []string{"move"}}},
+		Users: []conf.User{{Name: "alice", Password: hash, Roles: []string{"mover"}}},
+	}).handler()
+
+	var session *http.Cookie
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		return sendRequest(handler, method, path, "192.0.2.1:1", body, session, nil)
+	}
+
+	if rec := send(http.MethodGet, "/data", ""); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("listing without logging in should answer 401, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/metrics", ""); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("the metrics without logging in should answer 401, got %d", rec.Code)
+	}
+	if rec := send(http.MethodPost, "/api/v1/session", `{"username":"alice","password":"wrong"}`); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("a wrong password should answer 401, got %d", rec.Code)
+	}
+	rec := send(http.MethodPost, "/api/v1/session", `{"username":"alice","password":"secret"}`)
+	for _, c := range rec.Result().Cookies() {
+		if api.SessionCookie == c.Name && c.HttpOnly && http.SameSiteStrictMode == c.SameSite {
+			session = c
+		}
+	}
+	if http.StatusOK != rec.Code || nil == session {
+		t.Fatalf("login should set an HttpOnly SameSite session cookie, got %d %v", rec.Code, rec.Result().Cookies())
+	}
+
+	rec = send(http.MethodGet, "/data", "")
+	var data api.DataResponse
+	json.NewDecoder(rec.Body).Decode(&data)
+	if "alice" != data.User || 1 != len(data.Destinations) || 1 != len(data.SrcDirAndItsContents) || 1 != len(data.Operations) {
+		t.Fatalf("the listing should be limited to the role %v", data)
+	}
+
+	cases := []struct {
+		body   string
+		expect int
+	}{
+		{`{"operation":"move","src":"/dl","items":["good"],"dest":"media/movies"}`, http.StatusCreated},
+		{`{"operation":"copy","src":"/dl","items":["good"],"dest":"media/movies"}`, http.StatusForbidden},
+		{`{"operation":"move","src":"/other","items":["good"],"dest":"media/movies"}`, http.StatusForbidden},
+		{`{"operation":"move","src":"/dl","items":["good"],"dest":"media/shows"}`, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if rec = send(http.MethodPost, "/api/v1/operations", c.body); c.expect != rec.Code {
+			t.Fatalf("%s should answer %d, got %d", c.body, c.expect, rec.Code)
+		}
+	}
+	if rec = send(http.MethodPost, "/copy", `{"src":"/dl","items":["good"],"dest":"media/movies"}`); http.StatusForbidden != rec.Code {
+		t.Fatalf("copying through the ui route should be forbidden too, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/metrics", ""); http.StatusForbidden != rec.Code {
+		t.Fatalf("the metrics need the metrics operation, got %d", rec.Code)
+	}
+	open := newTestHandle(t, &conf.Configuration{}).handler()
+	if rec = sendRequest(open, http.MethodGet, "/metrics", "192.0.2.1:1", "", nil, nil); http.StatusOK != rec.Code {
+		t.Fatalf("without users the metrics should be served, got %d", rec.Code)
+	}
+
+	if rec = send(http.MethodDelete, "/api/v1/session", ""); http.StatusNoContent != rec.Code {
+		t.Fatalf("logout should answer 204, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/api/v1/sources", ""); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("the session should be gone after logout, got %d", rec.Code)
+	}
+}
+
+func TestSecondFactor(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+	handler := newTestHandle(t, &conf.Configuration{
+		AllowedCIDRs: []string{"0.0.0.0/0"},
+		Roles:        []conf.Role{{Name: "mover", Sources: []string{"*"}, Destinations: []string{"*"}, Operations: []string{"move"}}},
+		Users:        []conf.User{{Name: "alice", Password: hash, Roles: []string{"mover"}}},
+		TwoFactor:    conf.TwoFactor{RequireOutsideLAN: true},
+	}).handler()
+
+	send := func(method, path, remote, body string, session *http.Cookie) *httptest.ResponseRecorder {
+		return sendRequest(handler, method, path, remote, body, session, nil)
+	}
+	login := func(remote, body string) (*httptest.ResponseRecorder, *http.Cookie) {
+		rec := send(http.MethodPost, "/api/v1/session", remote, body, nil)
+		return rec, sessionCookie(rec)
+	}
+	code := func(secret string, offset time.Duration) string {
+		code, _ := auth.TOTPCode(secret, time.Now().Add(offset))
+		return code
+	}
+
+	if rec, _ := login("203.0.113.1:1", `{"username":"alice","password":"secret"}`); http.StatusForbidden != rec.Code {
+		t.Fatalf("without a second factor logging in from outside the LAN should be refused, got %d", rec.Code)
+	}
+	rec, lan := login("192.168.1.2:1", `{"username":"alice","password":"secret"}`)
+	if http.StatusOK != rec.Code {
+		t.Fatalf("logging in from the LAN should work, got %d", rec.Code)
+	}
+	if rec = send(http.MethodPut, "/api/v1/session/totp", "192.168.1.2:1", `{"code":"123456"}`, lan); http.StatusConflict != rec.Code {
+		t.Fatalf("confirming before enrolling should answer 409, got %d", rec.Code)
+	}
+	rec = send(http.MethodPost, "/api/v1/session/totp", "192.168.1.2:1", "{}", lan)
+	var enrollment api.TOTPEnrollment
+	json.NewDecoder(rec.Body).Decode(&enrollment)
+	if http.StatusOK != rec.Code || !strings.HasPrefix(enrollment.URI, "otpauth://totp/") {
+		t.Fatalf("enrolling should answer the secret, got %d %v", rec.Code, enrollment)
+	}
+	rec = send(http.MethodPut, "/api/v1/session/totp", "192.168.1.2:1", `{"code":"`+code(enrollment.Secret, -30*time.Second)+`"}`, lan)
+	var recovery api.RecoveryCodes
+	json.NewDecoder(rec.Body).Decode(&recovery)
+	if http.StatusOK != rec.Code || 0 == len(recovery.RecoveryCodes) {
+		t.Fatalf("confirming should answer the recovery codes, got %d", rec.Code)
+	}
+
+	if rec, _ = login("203.0.113.1:1", `{"username":"alice","password":"secret"}`); http.StatusUnauthorized != rec.Code || !strings.Contains(rec.Body.String(), api.CodeSecondFactor) {
+		t.Fatalf("logging in without the code should ask for it, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ = login("203.0.113.1:1", `{"username":"alice","password":"secret","code":"000000"}`); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("a wrong code should be refused, got %d", rec.Code)
+	}
+	rec, remote := login("203.0.113.1:1", `{"username":"alice","password":"secret","code":"`+code(enrollment.Secret, 0)+`"}`)
+	if http.StatusOK != rec.Code {
+		t.Fatalf("logging in with the code should work, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/api/v1/sources", "203.0.113.1:1", "", remote); http.StatusOK != rec.Code {
+		t.Fatalf("the session with a second factor should work outside the LAN, got %d", rec.Code)
+	}
+	if rec, _ = login("192.168.1.2:1", `{"username":"alice","password":"secret","code":"`+recovery.RecoveryCodes[0]+`"}`); http.StatusOK != rec.Code {
+		t.Fatalf("a recovery code should log in, got %d", rec.Code)
+	}
+	if rec, _ = login("192.168.1.2:1", `{"username":"alice","password":"secret","code":"`+recovery.RecoveryCodes[0]+`"}`); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("a recovery code should only be used once, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/api/v1/sources", "203.0.113.1:1", "", nil); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("anonymous clients outside the LAN should log in, got %d", rec.Code)
+	}
+	if rec = send(http.MethodDelete, "/api/v1/session/totp", "203.0.113.1:1", `{"code":""}`, remote); http.StatusBadRequest != rec.Code {
+		t.Fatalf("removing the second factor without a code should be refused, got %d", rec.Code)
+	}
+	if rec = send(http.MethodDelete, "/api/v1/session/totp", "203.0.113.1:1", `{"code":"`+recovery.RecoveryCodes[1]+`"}`, remote); http.StatusNoContent != rec.Code {
+		t.Fatalf("removing the second factor with a recovery code should work, got %d", rec.Code)
+	}
+	if rec, _ = login("203.0.113.1:1", `{"username":"alice","password":"secret"}`); http.StatusForbidden != rec.Code {
+		t.Fatalf("without the second factor logging in from outside the LAN should be refused again, got %d", rec.Code)
+	}
+}
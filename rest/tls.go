@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// loadTLS returns the tls configuration of the tcp listeners, nil without a
+// cert
+func loadTLS(c conf.TLS) (*tls.Config, error) {
+	if "" == c.Cert {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if nil != err {
+		return nil, errors.New("tls: " + err.Error())
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if "" == c.ClientCA {
+		return config, nil
+	}
+	pem, err := os.ReadFile(c.ClientCA)
+	if nil != err {
+		return nil, errors.New("tls: " + err.Error())
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("tls: no certificate in " + c.ClientCA)
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// wrapTLS serves https on the tcp listeners, unix sockets stay plain for the
+// reverse proxy in front of them
+func wrapTLS(l net.Listener, config *tls.Config) net.Listener {
+	if nil == config || "unix" == l.Addr().Network() {
+		return l
+	}
+	return tls.NewListener(l, config)
+}
+
+// clientCertificate is the certificate the client presented, verified
+// against the clientCA, nil without one
+func clientCertificate(r *http.Request) *x509.Certificate {
+	if nil == r.TLS || 0 == len(r.TLS.VerifiedChains) || 0 == len(r.TLS.VerifiedChains[0]) {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// certIdentity is the user or roles the clientCerts map the certificate of
+// the client to, nil when it has none or it is not mapped
+func (h *Handle) certIdentity(r *http.Request) *auth.Identity {
+	cert := clientCertificate(r)
+	if nil == cert {
+		return nil
+	}
+	for _, cc := range h.clientCerts {
+		if cc.Subject != cert.Subject.CommonName && cc.Subject != cert.Subject.String() {
+			continue
+		}
+		if "" == cc.User {
+			return &auth.Identity{Name: "cert:" + cert.Subject.CommonName, Roles: cc.Roles, Via: auth.ViaCertificate}
+		}
+		if roles, ok := h.authority.Roles(cc.User); ok {
+			return &auth.Identity{Name: cc.User, Roles: roles, Via: auth.ViaCertificate}
+		}
+	}
+	return nil
+}
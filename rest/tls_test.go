@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+// newCert creates a certificate for cn signed by parent, self signed when
+// parent is nil, and writes it and its key as pem files
+func newCert(t *testing.T, dir, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"home"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if nil == parent {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if nil != err {
+		t.Fatalf("could not create a certificate %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	os.WriteFile(filepath.Join(dir, cn+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, cn+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, key
+}
+
+func TestClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCert(t, dir, "ca", nil, nil)
+	newCert(t, dir, "server", ca, caKey)
+	newCert(t, dir, "phone", ca, caKey)
+	newCert(t, dir, "laptop", ca, caKey)
+	newCert(t, dir, "unmapped", ca, caKey)
+	other, otherKey := newCert(t, dir, "other-ca", nil, nil)
+	newCert(t, dir, "stranger", other, otherKey)
+
+	hash, _ := auth.HashPassword("secret")
+	h := newTestHandle(t, &conf.Configuration{
+		Roles: []conf.Role{{Name: "mover", Sources: []string{"*"}, Destinations: []string{"*"}, Operations: []string{"move"}}},
+		Users: []conf.User{{Name: "alice", Password: hash, Roles: []string{"mover"}}},
+		TLS: conf.TLS{
+			Cert:                   filepath.Join(dir, "server.pem"),
+			Key:                    filepath.Join(dir, "server.key"),
+			ClientCA:               filepath.Join(dir, "ca.pem"),
+			ClientCertsBypassCIDRs: true,
+			ClientCerts: []conf.ClientCert{
+				{Subject: "phone", User: "alice"},
+				{Subject: "CN=laptop,O=home", Roles: []string{"mover"}},
+			},
+		},
+	})
+	// 127.0.0.1 is not allowed, only client certificates get in, on a free
+	// port the configuration can't ask for
+	h.listen = []string{"127.0.0.1:0"}
+	if err := h.Listen(); nil != err {
+		t.Fatalf("could not listen %v", err)
+	}
+	go h.Serve()
+	defer h.listeners[0].Close()
+	url := "https://" + h.listeners[0].Addr().String() + "/api/v1/session"
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(name string) (int, api.SessionResponse) {
+		config := &tls.Config{RootCAs: roots}
+		if "" != name {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key"))
+			if nil != err {
+				t.Fatalf("could not load %s %v", name, err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := c.Get(url)
+		if nil != err {
+			return 0, api.SessionResponse{}
+		}
+		defer resp.Body.Close()
+		var sess api.SessionResponse
+		json.NewDecoder(resp.Body).Decode(&sess)
+		return resp.StatusCode, sess
+	}
+
+	if code, _ := get(""); http.StatusForbidden != code {
+		t.Fatalf("without a certificate the allowedCIDRs apply, got %d", code)
+	}
+	if code, _ := get("stranger"); http.StatusOK == code {
+		t.Fatalf("a certificate of another CA should not get in")
+	}
+	if code, _ := get("unmapped"); http.StatusForbidden != code {
+		t.Fatalf("a certificate clientCerts does not map should not bypass the allowedCIDRs, got %d", code)
+	}
+	if code, sess := get("phone"); http.StatusOK != code || "alice" != sess.User || auth.ViaCertificate != sess.Via {
+		t.Fatalf("the phone should be alice, got %d %v", code, sess)
+	}
+	if code, sess := get("laptop"); http.StatusOK != code || "cert:laptop" != sess.User || 1 != len(sess.Operations) {
+		t.Fatalf("the laptop should have the mover role, got %d %v", code, sess)
+	}
+}
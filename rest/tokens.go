@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func apiToken(t auth.Token) api.APIToken {
+	ret := api.APIToken{
+		ID:           t.ID,
+		Name:         t.Name,
+		CreatedBy:    t.CreatedBy,
+		Created:      t.Created,
+		Expires:      t.Expires,
+		Operations:   t.Operations,
+		Sources:      t.Sources,
+		Destinations: t.Destinations,
+	}
+	if !t.LastUsed.IsZero() {
+		ret.LastUsed = &t.LastUsed
+	}
+	return ret
+}
+
+// tokenIdentity is the identity of the bearer token of r, nil without one.
+// An invalid token is answered with a 401, other Authorization schemes, ex:
+// the basic auth of a reverse proxy, are left alone.
+func (h *Handle) tokenIdentity(w http.ResponseWriter, r *http.Request) (*auth.Identity, bool) {
+	scheme, raw, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold("Bearer", scheme) {
+		return nil, true
+	}
+	t, ok := h.tokens.Check(strings.TrimSpace(raw))
+	if !ok {
+		rejectedRequests.Inc("token")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Invalid or expired token")
+		return nil, false
+	}
+	return &auth.Identity{Name: "token:" + t.Name, Via: auth.ViaToken, Scope: t.Permissions()}, true
+}
+
+// authorizeAdmin answers 401 or 403 unless the client may manage the tokens
+func (h *Handle) authorizeAdmin(w http.ResponseWriter, r *http.Request) (*auth.Permissions, bool) {
+	perms, ok := h.permissions(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !perms.Can("admin") {
+		rejectedRequests.Inc("permission")
+		h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Not allowed to manage tokens")
+		return nil, false
+	}
+	return perms, true
+}
+
+// checkTokenRequest answers an error unless req is a valid token, allowing
+// only operations, sources and destinations perms allows too
+func (h *Handle) checkTokenRequest(w http.ResponseWriter, r *http.Request, perms *auth.Permissions, req *api.TokenRequest) bool {
+	msg := ""
+	switch {
+	case "" == strings.TrimSpace(req.Name):
+		msg = "The token needs a name"
+	case !req.Expires.After(time.Now()):
+		msg = "The token needs an expiry in the future"
+	case 0 == len(req.Operations):
+		msg = "The token needs operations"
+	case 0 == len(req.Sources):
+		msg = "The token needs sources"
+	case 0 == len(req.Destinations):
+		msg = "The token needs destinations"
+	}
+	for _, op := range req.Operations {
+		if "admin" == op || !slices.Contains(conf.Operations, op) || !perms.Can(op) {
+			msg = "Can't allow " + op + " to a token"
+		}
+	}
+	for _, p := range req.Sources {
+		if _, err := path.Match(p, ""); nil != err {
+			msg = "Invalid pattern " + p
+		} else if !perms.CanGrantRead(p) {
+			msg = "Can't allow the sources " + p + " to a token"
+		}
+	}
+	for _, p := range req.Destinations {
+		if _, err := path.Match(p, ""); nil != err {
+			msg = "Invalid pattern " + p
+		} else if !perms.CanGrantWrite(p) {
+			msg = "Can't allow the destinations " + p + " to a token"
+		}
+	}
+	if "" == msg {
+		return true
+	}
+	h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, msg)
+	return false
+}
+
+func (h *Handle) handleTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if _, ok := h.authorizeAdmin(w, r); !ok {
+			return
+		}
+		tokens := h.tokens.List()
+		ret := make([]api.APIToken, 0, len(tokens))
+		for _, t := range tokens {
+			ret = append(ret, apiToken(t))
+		}
+		writeJSON(w, http.StatusOK, ret)
+	case http.MethodPost:
+		h.createToken(w, r)
+	default:
+		h.methodNotAllowed(w, r, "GET, POST")
+	}
+}
+
+func (h *Handle) createToken(w http.ResponseWriter, r *http.Request) {
+	perms, ok := h.authorizeAdmin(w, r)
+	if !ok {
+		return
+	}
+	var req api.TokenRequest
+	if !h.decodeBody(w, r, &req) || !h.checkTokenRequest(w, r, perms, &req) {
+		return
+	}
+	id, _ := identity(r)
+	raw, t, err := h.tokens.Create(auth.Token{
+		Name:         req.Name,
+		CreatedBy:    id.Name,
+		Expires:      req.Expires,
+		Operations:   req.Operations,
+		Sources:      req.Sources,
+		Destinations: req.Destinations,
+	})
+	if nil != err {
+		slog.Error("could not save the token", "name", req.Name, "error", err)
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not save the token")
+		return
+	}
+	slog.Info("token created", "id", t.ID, "name", t.Name, "by", id.Name, "expires", t.Expires)
+	ret := apiToken(t)
+	ret.Token = raw
+	w.Header().Set("Location", h.basePath+api.APIPrefix+"/tokens/"+t.ID)
+	writeJSON(w, http.StatusCreated, ret)
+}
+
+func (h *Handle) handleToken(w http.ResponseWriter, r *http.Request) {
+	if http.MethodDelete != r.Method {
+		h.methodNotAllowed(w, r, "DELETE")
+		return
+	}
+	if _, ok := h.authorizeAdmin(w, r); !ok {
+		return
+	}
+	id, ok := h.pathID(r, "/tokens/")
+	revoked := false
+	var err error
+	if ok {
+		revoked, err = h.tokens.Revoke(id)
+	}
+	if nil != err {
+		slog.Error("could not revoke the token", "id", id, "error", err)
+		h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not revoke the token")
+		return
+	}
+	if !revoked {
+		h.writeError(w, r, http.StatusNotFound, api.CodeNotFound, "Token not found")
+		return
+	}
+	who, _ := identity(r)
+	slog.Info("token revoked", "id", id, "by", who.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
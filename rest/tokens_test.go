@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestTokens(t *testing.T) {
+	hash, _ := auth.HashPassword("secret")
+	handler := newTestHandle(t, &conf.Configuration{
+		Roles: []conf.Role{{Name: "admin", Sources: []string{"/dl", "/srv/*"}, Destinations: []string{"media"}, Operations: []string{"move", "copy", "admin"}}},
+		Users: []conf.User{{Name: "root", Password: hash, Roles: []string{"admin"}}},
+	}).handler()
+
+	send := func(method, path, body, bearer string, session *http.Cookie) *httptest.ResponseRecorder {
+		var headers map[string]string
+		if "" != bearer {
+			headers = map[string]string{"Authorization": "Bearer " + bearer}
+		}
+		return sendRequest(handler, method, path, "192.0.2.1:1", body, session, headers)
+	}
+
+	rec := send(http.MethodPost, "/api/v1/session", `{"username":"root","password":"secret"}`, "", nil)
+	session := sessionCookie(rec)
+	if rec = send(http.MethodPost, "/api/v1/tokens", `{"name":"bad","expires":"2000-01-01T00:00:00Z","operations":["move"]}`, "", session); http.StatusBadRequest != rec.Code {
+		t.Fatalf("an expired token should not be created, got %d", rec.Code)
+	}
+	refused := map[string]string{
+		"without sources":      `"destinations":["media"]`,
+		"without destinations": `"sources":["/dl"]`,
+		"with any source":      `"sources":["*"],"destinations":["media"]`,
+		"with other sources":   `"sources":["/*"],"destinations":["media"]`,
+		"with other roots":     `"sources":["/dl"],"destinations":["media","archive"]`,
+	}
+	for name, patterns := range refused {
+		body := `{"name":"bad","expires":"2100-01-01T00:00:00Z","operations":["move"],` + patterns + `}`
+		if rec = send(http.MethodPost, "/api/v1/tokens", body, "", session); http.StatusBadRequest != rec.Code {
+			t.Fatalf("a token %s should not be created, got %d", name, rec.Code)
+		}
+	}
+	rec = send(http.MethodPost, "/api/v1/tokens", `{"name":"scripts","expires":"2100-01-01T00:00:00Z","operations":["move"],"sources":["/dl"],"destinations":["media/movies"]}`, "", session)
+	var created api.APIToken
+	json.NewDecoder(rec.Body).Decode(&created)
+	if http.StatusCreated != rec.Code || "" == created.Token || "root" != created.CreatedBy {
+		t.Fatalf("the token should be created with its secret, got %d %v", rec.Code, created)
+	}
+
+	if rec = send(http.MethodGet, "/api/v1/sources", "", created.Token+"x", nil); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("a wrong token should answer 401, got %d", rec.Code)
+	}
+	if rec = send(http.MethodPost, "/move", `{"src":"/dl","items":["good"],"dest":"media/movies"}`, created.Token, nil); http.StatusOK != rec.Code {
+		t.Fatalf("the token should move, got %d", rec.Code)
+	}
+	if rec = send(http.MethodPost, "/copy", `{"src":"/dl","items":["good"],"dest":"media/movies"}`, created.Token, nil); http.StatusForbidden != rec.Code {
+		t.Fatalf("the token should not copy, got %d", rec.Code)
+	}
+	if rec = send(http.MethodPost, "/move", `{"src":"/dl","items":["good"],"dest":"media/shows"}`, created.Token, nil); http.StatusForbidden != rec.Code {
+		t.Fatalf("the token should not move elsewhere, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/api/v1/tokens", "", created.Token, nil); http.StatusForbidden != rec.Code {
+		t.Fatalf("the token should not manage tokens, got %d", rec.Code)
+	}
+
+	rec = send(http.MethodGet, "/api/v1/tokens", "", "", session)
+	var list []api.APIToken
+	json.NewDecoder(rec.Body).Decode(&list)
+	if 1 != len(list) || "" != list[0].Token || nil == list[0].LastUsed {
+		t.Fatalf("the list should have the used token, without its secret %v", list)
+	}
+	if rec = send(http.MethodDelete, "/api/v1/tokens/"+created.ID, "", "", session); http.StatusNoContent != rec.Code {
+		t.Fatalf("revoking should answer 204, got %d", rec.Code)
+	}
+	if rec = send(http.MethodGet, "/api/v1/sources", "", created.Token, nil); http.StatusUnauthorized != rec.Code {
+		t.Fatalf("a revoked token should answer 401, got %d", rec.Code)
+	}
+}
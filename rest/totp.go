@@ -0,0 +1,147 @@
+package rest
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/auth"
+)
+
+// onLAN reports if the client is in the lanCIDRs, by default the loopback,
+// private and link local ranges
+func (h *Handle) onLAN(r *http.Request) bool {
+	addr, ok := clientIP(r)
+	if !ok {
+		return false
+	}
+	if 0 != len(h.lanCIDRs) {
+		return containsAddr(h.lanCIDRs, addr)
+	}
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+}
+
+// secondFactorRequired reports if the client has to log in with a second
+// factor, anonymous clients included
+func (h *Handle) secondFactorRequired(r *http.Request) bool {
+	return h.requireOutsideLAN && !h.onLAN(r)
+}
+
+func (h *Handle) enrolled(user string) bool {
+	return nil != h.totp && h.totp.Enrolled(user)
+}
+
+// checkSecondFactor checks the code of a user logging in, if it has a second
+// factor, and answers the error when it fails. It returns if a second factor
+// was given.
+func (h *Handle) checkSecondFactor(w http.ResponseWriter, r *http.Request, user, code string) (bool, bool) {
+	if !h.enrolled(user) {
+		if h.secondFactorRequired(r) {
+			rejectedRequests.Inc("login")
+			h.writeError(w, r, http.StatusForbidden, api.CodeForbidden, "Two-factor authentication is required outside the LAN, enroll from the LAN first")
+			return false, false
+		}
+		return false, true
+	}
+	if "" == code {
+		h.writeError(w, r, http.StatusUnauthorized, api.CodeSecondFactor, "Enter the code of your authenticator app or a recovery code")
+		return false, false
+	}
+	if err := h.totp.Verify(user, code); nil != err {
+		if errors.Is(err, auth.ErrInvalidCode) {
+			rejectedRequests.Inc("login")
+			client, _ := clientIP(r)
+			slog.Warn("second factor failed", "user", user, "client", addrString(client))
+			h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Invalid code")
+		} else {
+			slog.Error("could not check the second factor", "user", user, "error", err)
+			h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not check the code")
+		}
+		return false, false
+	}
+	return true, true
+}
+
+// handleTOTP shows, enrolls and removes the second factor of the logged in
+// user. Enrolling is a POST for a new secret, then a PUT of a code from the
+// authenticator app, which answers the recovery codes. Removing it takes a
+// current code or a recovery code, a stolen session is not enough.
+func (h *Handle) handleTOTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete:
+	default:
+		h.methodNotAllowed(w, r, "GET, POST, PUT, DELETE")
+		return
+	}
+	id, ok := identity(r)
+	token, ss, hasSession := h.currentSession(r)
+	if !ok || auth.ViaSession != id.Via || !hasSession {
+		h.writeError(w, r, http.StatusUnauthorized, api.CodeUnauthorized, "Log in first")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, api.TOTPStatus{
+			Enabled:           h.enrolled(id.Name),
+			RecoveryCodesLeft: h.totp.RecoveryCodesLeft(id.Name),
+			Required:          h.secondFactorRequired(r),
+		})
+	case http.MethodPost:
+		secret, err := auth.NewTOTPSecret()
+		if nil != err {
+			h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not create a secret")
+			return
+		}
+		h.sessions.update(token, func(s *session) { s.enrolling = secret })
+		writeJSON(w, http.StatusOK, api.TOTPEnrollment{Secret: secret, URI: auth.TOTPURI(h.totpIssuer, id.Name, secret)})
+	case http.MethodPut:
+		var req api.TOTPCode
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		if "" == ss.enrolling {
+			h.writeError(w, r, http.StatusConflict, api.CodeConflict, "Start the enrollment first")
+			return
+		}
+		codes, err := h.totp.Enroll(id.Name, ss.enrolling, req.Code)
+		if errors.Is(err, auth.ErrInvalidCode) {
+			h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid code, check the clock of the device")
+			return
+		}
+		if nil != err {
+			slog.Error("could not enroll the second factor", "user", id.Name, "error", err)
+			h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not save the second factor")
+			return
+		}
+		h.sessions.update(token, func(s *session) {
+			s.enrolling = ""
+			s.secondFactor = true
+		})
+		slog.Info("second factor enrolled", "user", id.Name)
+		writeJSON(w, http.StatusOK, api.RecoveryCodes{RecoveryCodes: codes})
+	case http.MethodDelete:
+		var req api.TOTPCode
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		if err := h.totp.Verify(id.Name, req.Code); nil != err {
+			if errors.Is(err, auth.ErrInvalidCode) {
+				client, _ := clientIP(r)
+				slog.Warn("second factor removal refused", "user", id.Name, "client", addrString(client))
+				h.writeError(w, r, http.StatusBadRequest, api.CodeBadRequest, "Invalid code")
+			} else {
+				slog.Error("could not check the second factor", "user", id.Name, "error", err)
+				h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not check the code")
+			}
+			return
+		}
+		if err := h.totp.Disable(id.Name); nil != err {
+			slog.Error("could not remove the second factor", "user", id.Name, "error", err)
+			h.writeError(w, r, http.StatusInternalServerError, api.CodeInternal, "Could not remove the second factor")
+			return
+		}
+		slog.Info("second factor removed", "user", id.Name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,34 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/webhook"
+)
+
+// fireOperation fires the webhooks of the outcome of an operation of the
+// client of r
+func (h *Handle) fireOperation(r *http.Request, id, op, src, dest string, items []webhook.Item) {
+	user := ""
+	if who, ok := identity(r); ok {
+		user = who.Name
+	}
+	h.webhooks.Fire(webhook.NewEvent(id, user, op, src, dest, items))
+}
+
+func (h *Handle) handleDeliveries(w http.ResponseWriter, r *http.Request) {
+	if http.MethodGet != r.Method {
+		h.methodNotAllowed(w, r, "GET")
+		return
+	}
+	if _, ok := h.authorizeAdmin(w, r); !ok {
+		return
+	}
+	deliveries := h.webhooks.Deliveries(r.URL.Query().Get("webhook"))
+	ret := make([]api.WebhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		ret = append(ret, api.WebhookDelivery(d))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
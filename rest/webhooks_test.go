@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/api"
+	"github.com/shoaib42/remote-move/conf"
+	"github.com/shoaib42/remote-move/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []webhook.Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e webhook.Event
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &e)
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	h := newTestHandle(t, &conf.Configuration{
+		Webhooks: []conf.Webhook{{Name: "all", URL: receiver.URL, ContentType: "application/json", Timeout: time.Second, Attempts: 1}},
+	})
+	handler := h.handler()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		return sendRequest(handler, method, path, "192.0.2.1:1", body, nil, nil)
+	}
+
+	rec := send(http.MethodPost, "/api/v1/operations", `{"operation":"move","src":"/dl","items":["good"],"dest":"media/movies"}`)
+	var op api.Operation
+	json.NewDecoder(rec.Body).Decode(&op)
+	send(http.MethodPost, "/copy", `{"src":"/dl","items":["good","gone"],"dest":"media/movies"}`)
+	if rec = send(http.MethodPost, "/move", `{"src":"/dl","items":[],"dest":"media/movies"}`); http.StatusBadRequest != rec.Code {
+		t.Fatalf("moving no items should answer 400, got %d", rec.Code)
+	}
+	if err := h.Shutdown(context.Background()); nil != err {
+		t.Fatalf("shutting down should wait for the deliveries, got %v", err)
+	}
+
+	mu.Lock()
+	if 2 != len(events) {
+		t.Fatalf("both operations should fire the webhook, got %v", events)
+	}
+	byType := map[string]webhook.Event{events[0].Type: events[0], events[1].Type: events[1]}
+	if e := byType["move.succeeded"]; op.ID != e.ID || "media/movies" != e.Dest || 1 != len(e.Items) {
+		t.Fatalf("the move should fire move.succeeded, got %v", events)
+	}
+	if e := byType["copy.failed"]; 2 != len(e.Items) || http.StatusNotFound != e.Items[1].Status || "" == e.Items[1].Error {
+		t.Fatalf("the copy of a missing item should fire copy.failed, got %v", events)
+	}
+	mu.Unlock()
+	rec = send(http.MethodGet, "/api/v1/operations/"+byType["copy.failed"].ID, "")
+	if json.NewDecoder(rec.Body).Decode(&op); http.StatusOK != rec.Code || "copy" != op.Operation || http.StatusMultiStatus != op.Status {
+		t.Fatalf("the operation of the event of the ui route should be kept, got %d %v", rec.Code, op)
+	}
+
+	var deliveries []api.WebhookDelivery
+	rec = send(http.MethodGet, "/api/v1/webhooks/deliveries?webhook=all", "")
+	json.NewDecoder(rec.Body).Decode(&deliveries)
+	if http.StatusOK != rec.Code || 2 != len(deliveries) || webhook.StatusDelivered != deliveries[0].Status || 200 != deliveries[0].ResponseStatus {
+		t.Fatalf("the deliveries should be listed, got %d %v", rec.Code, deliveries)
+	}
+	rec = send(http.MethodGet, "/api/v1/webhooks/deliveries?webhook=other", "")
+	if json.NewDecoder(rec.Body).Decode(&deliveries); 0 != len(deliveries) {
+		t.Fatalf("the deliveries of another webhook should be empty, got %v", deliveries)
+	}
+}
@@ -1,3 +1,18 @@
+/*
+Base path the ui and api are served under, set by the server, empty for /
+*/
+function basePath() {
+  return document.querySelector('meta[name="base-path"]').content;
+}
+
+/*
+Token of the CSRF cookie set by the server, sent back with every move/copy
+*/
+function csrfToken() {
+  const cookie = document.cookie.split("; ").find(c => c.startsWith("remote_move_csrf="));
+  return cookie ? cookie.substring("remote_move_csrf=".length) : "";
+}
+
 function createOption(value, text) {
   const option = document.createElement("option");
   option.value = value;
@@ -10,12 +25,14 @@ function createPlaceholderOption(text) {
 }
 
 function populateOptions(jsonData) {
-  const { srcDirAndItsContents, destination } = jsonData;
+  const { srcDirAndItsContents, destinations, destinationPolicies } = jsonData;
     const srcDirs = document.getElementById("sourceDirs");
     const items = document.getElementById("items");
     const destDirs = document.getElementById("destinationDirs");
     const messageElement = document.getElementById("listingMessage");
+    const policyElement = document.getElementById("destinationPolicy");
     messageElement.textContent = "";
+    policyElement.textContent = "";
 
     if (jsonData.listingErrors) {
       srcDirs.innerHTML = "";
@@ -38,7 +55,37 @@ function populateOptions(jsonData) {
 
     destDirs.innerHTML = "";
     destDirs.appendChild(createPlaceholderOption("-- select a destination directory --"));
-    destDirs.append(...destination.map(a => createOption(a, a)));
+    Object.keys(destinations).forEach(root => {
+      const group = document.createElement("optgroup");
+      group.label = root;
+      group.append(...destinations[root].map(a => createOption(root + "/" + a, a)));
+      destDirs.appendChild(group);
+    });
+    destDirs.onchange = function() {
+      const p = destinationPolicies[this.value];
+      policyElement.textContent = p ? "owner " + p.owner + ", files " + p.fileMode + ", dirs " + p.dirMode + (p.setgidDirs ? " +setgid" : "") : "";
+    }
+}
+
+/*
+Friendly messages for the error codes of the api
+*/
+const errorMessages = {
+  bad_request: "the request was incomplete, select a source, items and a destination",
+  invalid_name: "the name is not valid",
+  not_found: "not found, it may have been moved already, refresh and try again",
+  not_accessible: "the directory is not accessible, refresh and try again",
+  permission_denied: "permission denied on the server",
+  conflict: "already exists in the destination",
+  cross_device: "the destination is on another disk, copy it instead",
+  no_space: "no space left on the destination",
+  ownership_failed: "done, but the owner or permissions could not be set",
+};
+
+function failureMessage(failure) {
+  const friendly = errorMessages[failure.code];
+  const what = failure.code === "ownership_failed" ? failure.destination : failure.source;
+  return what + ": " + (friendly ? friendly : failure.message);
 }
 
 /*
@@ -48,24 +95,207 @@ function checkCMResponse(jsonData) {
   populateOptions(jsonData)
   const messageElement = document.getElementById("opMessage");
   if ('opResponse' in jsonData && jsonData.opResponse !== null && jsonData.opResponse.length > 0) {
-    messageElement.textContent = JSON.stringify(jsonData);;
+    messageElement.textContent = jsonData.opResponse.map(failureMessage).join("\n");
+    messageElement.title = jsonData.opResponse.map(f => f.message).join("\n");
     messageElement.style.color = "red";
   } else {
     messageElement.textContent = "Success";
+    messageElement.title = "";
     messageElement.style.color = "green";
   }
 
 }
 
+/*
+Show the login form, or the move form with the buttons of the allowed operations
+*/
+function showSession(jsonData) {
+  const loggedIn = jsonData !== null;
+  document.getElementById("loginForm").hidden = loggedIn;
+  document.getElementById("moveForm").hidden = !loggedIn;
+  document.getElementById("session").hidden = !loggedIn || !jsonData.user;
+  if (!loggedIn || !jsonData.user) {
+    document.getElementById("totpForm").hidden = true;
+  }
+  if (!loggedIn) {
+    return;
+  }
+  document.getElementById("sessionUser").textContent = jsonData.user;
+  // only sessions can log out and enroll a second factor
+  const session = jsonData.via === "session";
+  document.getElementById("logoutButton").hidden = !session;
+  document.getElementById("totpButton").hidden = !session;
+  const operations = jsonData.operations || [];
+  document.getElementById("moveButton").hidden = !operations.includes("move");
+  document.getElementById("copyButton").hidden = !operations.includes("copy");
+}
+
 function refreshOptions() {
-  fetch("/data", {
+  fetch(basePath() + "/data", {
     method: "GET",
     headers: {
       "Accept": "application/json",
     },
   })
-  .then(response => response.json())
-  .then(jsonData => populateOptions(jsonData))
+  .then(response => {
+    if (response.status === 401) {
+      showSession(null);
+      return null;
+    }
+    return response.json();
+  })
+  .then(jsonData => {
+    if (jsonData !== null) {
+      showSession(jsonData);
+      populateOptions(jsonData);
+    }
+  })
+}
+
+function login() {
+  const messageElement = document.getElementById("loginMessage");
+  fetch(basePath() + "/api/v1/session", {
+    method: "POST",
+    headers: {
+        "Content-Type": "application/json",
+        "X-CSRF-Token": csrfToken()
+    },
+    body: JSON.stringify({
+      username: document.getElementById("username").value,
+      password: document.getElementById("password").value,
+      code: document.getElementById("code").value
+    })
+  })
+  .then(response => response.json().then(jsonData => ({ ok: response.ok, jsonData: jsonData })))
+  .then(({ ok, jsonData }) => {
+    document.getElementById("code").value = "";
+    if (!ok && jsonData.error && jsonData.error.code === "second_factor_required") {
+      // keep the password for the second step
+      document.getElementById("codeGroup").hidden = false;
+      messageElement.textContent = jsonData.error.message;
+      messageElement.style.color = "";
+      document.getElementById("code").focus();
+      return;
+    }
+    document.getElementById("password").value = "";
+    document.getElementById("codeGroup").hidden = true;
+    if (!ok) {
+      messageElement.textContent = jsonData.error ? jsonData.error.message : "Login failed";
+      messageElement.style.color = "red";
+      return;
+    }
+    messageElement.textContent = "";
+    refreshOptions();
+  })
+}
+
+/*
+Show the second factor of the user, the enrollment secret and the recovery
+codes are only shown once, as answered
+*/
+function showTOTP(status) {
+  document.getElementById("totpEnrollment").hidden = true;
+  document.getElementById("totpConfirmButton").hidden = true;
+  document.getElementById("totpEnrollButton").textContent = status.enabled ? "Enroll a new device" : "Enroll";
+  document.getElementById("totpDisableButton").hidden = !status.enabled;
+  document.getElementById("totpDisable").hidden = !status.enabled;
+  document.getElementById("totpStatus").textContent = status.enabled ?
+    "Two-factor authentication is enabled, " + status.recoveryCodesLeft + " recovery codes left" :
+    "Two-factor authentication is disabled" + (status.required ? ", it is required outside the LAN" : "");
+}
+
+function totpRequest(method, body) {
+  const messageElement = document.getElementById("totpMessage");
+  const headers = { "Accept": "application/json", "X-CSRF-Token": csrfToken() };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  return fetch(basePath() + "/api/v1/session/totp", {
+    method: method,
+    headers: headers,
+    body: body === undefined ? undefined : JSON.stringify(body)
+  })
+  .then(response => {
+    if (response.status === 204) {
+      return {};
+    }
+    return response.json().then(jsonData => {
+      if (!response.ok) {
+        messageElement.textContent = jsonData.error ? jsonData.error.message : "Failed";
+        messageElement.style.color = "red";
+        return null;
+      }
+      messageElement.textContent = "";
+      return jsonData;
+    });
+  })
+}
+
+function refreshTOTP() {
+  totpRequest("GET").then(status => {
+    if (status !== null) {
+      showTOTP(status);
+    }
+  })
+}
+
+function toggleTOTP() {
+  const form = document.getElementById("totpForm");
+  form.hidden = !form.hidden;
+  document.getElementById("recoveryCodes").hidden = true;
+  if (!form.hidden) {
+    refreshTOTP();
+  }
+}
+
+function enrollTOTP() {
+  totpRequest("POST", {}).then(enrollment => {
+    if (enrollment === null) {
+      return;
+    }
+    document.getElementById("totpURI").href = enrollment.uri;
+    document.getElementById("totpSecret").textContent = enrollment.secret;
+    document.getElementById("totpEnrollment").hidden = false;
+    document.getElementById("totpConfirmButton").hidden = false;
+    document.getElementById("recoveryCodes").hidden = true;
+  })
+}
+
+function confirmTOTP() {
+  const code = document.getElementById("totpCode");
+  totpRequest("PUT", { code: code.value }).then(recovery => {
+    if (recovery === null) {
+      return;
+    }
+    code.value = "";
+    document.getElementById("totpSecret").textContent = "";
+    document.getElementById("totpURI").removeAttribute("href");
+    const codes = document.getElementById("recoveryCodes");
+    codes.textContent = "Recovery codes, each logs in once instead of a code. Keep them safe, they are shown only once.\n\n" + recovery.recoveryCodes.join("\n");
+    codes.hidden = false;
+    refreshTOTP();
+  })
+}
+
+function disableTOTP() {
+  const code = document.getElementById("totpDisableCode");
+  totpRequest("DELETE", { code: code.value }).then(done => {
+    if (done !== null) {
+      code.value = "";
+      document.getElementById("recoveryCodes").hidden = true;
+      refreshTOTP();
+    }
+  })
+}
+
+function logout() {
+  fetch(basePath() + "/api/v1/session", {
+    method: "DELETE",
+    headers: {
+        "X-CSRF-Token": csrfToken()
+    }
+  })
+  .then(() => refreshOptions())
 }
 
 function handleOp(op) {
@@ -80,15 +310,34 @@ function handleOp(op) {
     dest: dest
   };
 
-  fetch("/"+op, {
+  fetch(basePath() + "/" + op, {
     method: "POST",
     headers: {
-        "Content-Type": "application/json"
+        "Content-Type": "application/json",
+        "X-CSRF-Token": csrfToken()
     },
     body: JSON.stringify(payload)
   })
-  .then(response => response.json())
-  .then(jsonData => checkCMResponse(jsonData))
+  .then(response => {
+    if (response.status === 401) {
+      showSession(null);
+      return null;
+    }
+    if (!response.ok) {
+      return response.text().then(text => {
+        const messageElement = document.getElementById("opMessage");
+        messageElement.textContent = text;
+        messageElement.style.color = "red";
+        return null;
+      });
+    }
+    return response.json();
+  })
+  .then(jsonData => {
+    if (jsonData !== null) {
+      checkCMResponse(jsonData);
+    }
+  })
 
 }
 
@@ -97,6 +346,18 @@ window.onload = function() {
   refreshOptions();
   const moveButton = document.getElementById("moveButton");
   const copyButton = document.getElementById("copyButton");
+  document.getElementById("loginForm").addEventListener("submit", function(event) {
+    event.preventDefault();
+    login();
+  });
+  document.getElementById("logoutButton").addEventListener("click", logout);
+  document.getElementById("totpButton").addEventListener("click", toggleTOTP);
+  document.getElementById("totpEnrollButton").addEventListener("click", enrollTOTP);
+  document.getElementById("totpDisableButton").addEventListener("click", disableTOTP);
+  document.getElementById("totpForm").addEventListener("submit", function(event) {
+    event.preventDefault();
+    confirmTOTP();
+  });
   const form = document.getElementById("moveForm");
   form.addEventListener("submit", function(event) {
     event.preventDefault();
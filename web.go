@@ -0,0 +1,20 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+//go:embed index.html static
+var embeddedAssets embed.FS
+
+// assets is the web ui served, the embedded one unless assetsDir is set
+func assets() fs.FS {
+	if "" != conf.Confs.AssetsDir {
+		return os.DirFS(conf.Confs.AssetsDir)
+	}
+	return embeddedAssets
+}
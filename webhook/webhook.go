@@ -0,0 +1,351 @@
+// Package webhook posts the outcome of the operations to the configured
+// webhooks, retrying failed deliveries, and keeps the last deliveries for the
+// API to show.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/shoaib42/remote-move/conf"
+	"github.com/shoaib42/remote-move/metrics"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// maxDeliveries is how many deliveries are kept for the API
+const maxDeliveries = 100
+
+// maxBackoff caps the doubling backoff between attempts
+const maxBackoff = 5 * time.Minute
+
+// SignatureTolerance is how old a signature Verify accepts, receivers should
+// refuse older ones as replays
+const SignatureTolerance = 5 * time.Minute
+
+var deliveries = metrics.Default.NewCounterVec("remote_move_webhook_deliveries_total", "Webhook deliveries by webhook and final status.", "webhook", "status")
+
+// Item is the outcome of an item of the operation, Status its HTTP status
+type Item struct {
+	Item   string `json:"item"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Event is the outcome of an operation, Type is <operation>.succeeded when
+// every item succeeded, else <operation>.failed
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Operation string    `json:"operation"`
+	Src       string    `json:"src"`
+	Dest      string    `json:"dest"`
+	Items     []Item    `json:"items"`
+}
+
+// NewEvent is the event of the operation op, from src to dest, by user
+func NewEvent(id, user, op, src, dest string, items []Item) Event {
+	outcome := "succeeded"
+	for _, i := range items {
+		if i.Status >= 300 {
+			outcome = "failed"
+		}
+	}
+	return Event{ID: id, Type: op + "." + outcome, Time: time.Now(), User: user, Operation: op, Src: src, Dest: dest, Items: items}
+}
+
+// Delivery is the posting of an event to a webhook. ResponseStatus and
+// Error are those of the last attempt.
+type Delivery struct {
+	ID             string
+	Webhook        string
+	Event          string
+	EventID        string
+	Status         string
+	Attempts       int
+	ResponseStatus int
+	Error          string
+	Created        time.Time
+	Updated        time.Time
+}
+
+type hook struct {
+	conf.Webhook
+	body   *template.Template
+	client *http.Client
+}
+
+// Dispatcher delivers the events to the webhooks in the background. A nil
+// Dispatcher has no webhooks.
+type Dispatcher struct {
+	hooks      []hook
+	mu         sync.Mutex
+	deliveries []*Delivery
+	wg         sync.WaitGroup
+	closing    chan struct{}
+	closeOnce  sync.Once
+}
+
+// New returns the dispatcher of the resolved webhooks, nil without any
+func New(webhooks []conf.Webhook) (*Dispatcher, error) {
+	if 0 == len(webhooks) {
+		return nil, nil
+	}
+	d := &Dispatcher{hooks: make([]hook, 0, len(webhooks)), closing: make(chan struct{})}
+	for _, w := range webhooks {
+		h := hook{Webhook: w, client: &http.Client{Timeout: w.Timeout}}
+		if "" != w.Body {
+			t, err := template.New(w.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(w.Body)
+			if nil != err {
+				return nil, errors.New("webhook " + w.Name + ": invalid body template " + err.Error())
+			}
+			h.body = t
+		}
+		d.hooks = append(d.hooks, h)
+	}
+	return d, nil
+}
+
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// subscribed reports if the hook is fired on events of type typ
+func (h *hook) subscribed(typ string) bool {
+	if 0 == len(h.Events) {
+		return true
+	}
+	for _, e := range h.Events {
+		if ok, _ := path.Match(e, typ); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire delivers e to the webhooks subscribed to it, without waiting
+func (d *Dispatcher) Fire(e Event) {
+	if nil == d {
+		return
+	}
+	for n := range d.hooks {
+		h := &d.hooks[n]
+		if !h.subscribed(e.Type) {
+			continue
+		}
+		now := time.Now()
+		dl := &Delivery{ID: newID(), Webhook: h.Name, Event: e.Type, EventID: e.ID, Status: StatusPending, Created: now, Updated: now}
+		d.mu.Lock()
+		d.deliveries = append(d.deliveries, dl)
+		if len(d.deliveries) > maxDeliveries {
+			d.deliveries = d.deliveries[len(d.deliveries)-maxDeliveries:]
+		}
+		d.mu.Unlock()
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(h, dl, e)
+		}()
+	}
+}
+
+// Deliveries returns the last deliveries to webhook, of every webhook when
+// empty, oldest first
+func (d *Dispatcher) Deliveries(webhook string) []Delivery {
+	ret := make([]Delivery, 0)
+	if nil == d {
+		return ret
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, dl := range d.deliveries {
+		if "" == webhook || dl.Webhook == webhook {
+			ret = append(ret, *dl)
+		}
+	}
+	return ret
+}
+
+// Wait waits for the deliveries in progress
+func (d *Dispatcher) Wait() {
+	if nil != d {
+		d.wg.Wait()
+	}
+}
+
+// Shutdown makes the deliveries waiting to retry try again right away and
+// waits for them until ctx is done, logging those it gives up on
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	if nil == d {
+		return nil
+	}
+	d.closeOnce.Do(func() { close(d.closing) })
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	for _, dl := range d.Deliveries("") {
+		if StatusPending == dl.Status {
+			slog.Warn("webhook delivery abandoned", "webhook", dl.Webhook, "event", dl.Event, "delivery", dl.ID, "attempts", dl.Attempts)
+		}
+	}
+	return ctx.Err()
+}
+
+// update records the outcome of an attempt of dl
+func (d *Dispatcher) update(dl *Delivery, status string, responseStatus int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.Status = status
+	dl.ResponseStatus = responseStatus
+	dl.Error = ""
+	if nil != err {
+		dl.Error = err.Error()
+	}
+	dl.Updated = time.Now()
+}
+
+// deliver posts e to h until it is accepted, the attempts are used up or the
+// webhook answers an error retrying won't fix
+func (d *Dispatcher) deliver(h *hook, dl *Delivery, e Event) {
+	body, err := h.render(e)
+	if nil != err {
+		slog.Error("could not render the webhook body", "webhook", h.Name, "event", e.Type, "error", err)
+		d.update(dl, StatusFailed, 0, err)
+		deliveries.Inc(h.Name, StatusFailed)
+		return
+	}
+	backoff := h.Backoff
+	for attempt := 1; ; attempt++ {
+		d.mu.Lock()
+		dl.Attempts = attempt
+		d.mu.Unlock()
+		status, err := h.post(dl.ID, e.Type, body)
+		if nil == err {
+			d.update(dl, StatusDelivered, status, nil)
+			deliveries.Inc(h.Name, StatusDelivered)
+			slog.Debug("webhook delivered", "webhook", h.Name, "event", e.Type, "delivery", dl.ID, "attempts", attempt)
+			return
+		}
+		if attempt >= h.Attempts || !retryable(status) {
+			d.update(dl, StatusFailed, status, err)
+			deliveries.Inc(h.Name, StatusFailed)
+			slog.Warn("webhook delivery failed", "webhook", h.Name, "event", e.Type, "delivery", dl.ID, "attempts", attempt, "error", err)
+			return
+		}
+		d.update(dl, StatusPending, status, err)
+		select {
+		case <-time.After(backoff):
+		case <-d.closing:
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// retryable reports if an attempt answered status, 0 when it got no answer,
+// may succeed later
+func retryable(status int) bool {
+	return 0 == status || http.StatusRequestTimeout == status || http.StatusTooManyRequests == status || status >= 500
+}
+
+// render is the body of e, the template of the webhook or else e as JSON
+func (h *hook) render(e Event) ([]byte, error) {
+	if nil == h.body {
+		return json.Marshal(e)
+	}
+	var buf bytes.Buffer
+	if err := h.body.Execute(&buf, e); nil != err {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign is the signature of body sent at t with secret,
+// t=<unix time>,sha256=<hex HMAC-SHA256 of <unix time>.<body>>
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",sha256=" + mac(secret, ts, body)
+}
+
+func mac(secret, ts string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(ts + "."))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Verify reports if signature is the one of body with secret, made at most
+// SignatureTolerance before or after now
+func Verify(secret, signature string, body []byte, now time.Time) bool {
+	ts, sum, ok := strings.Cut(signature, ",sha256=")
+	ts, found := strings.CutPrefix(ts, "t=")
+	if !ok || !found {
+		return false
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if nil != err {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return false
+	}
+	return hmac.Equal([]byte(sum), []byte(mac(secret, ts, body)))
+}
+
+// post makes an attempt, returning the status answered, 0 without an answer
+func (h *hook) post(id, typ string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if nil != err {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", h.ContentType)
+	req.Header.Set("User-Agent", "remote-move")
+	req.Header.Set("X-Remote-Move-Event", typ)
+	req.Header.Set("X-Remote-Move-Delivery", id)
+	if "" != h.Secret {
+		req.Header.Set(h.SignatureHeader, Sign(h.Secret, time.Now(), body))
+	}
+	resp, err := h.client.Do(req)
+	if nil != err {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New("webhook answered " + strconv.Itoa(resp.StatusCode))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shoaib42/remote-move/conf"
+)
+
+func TestDeliveries(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]*http.Request)
+	bodies := make(map[string][]string)
+	answers := map[string][]int{"/flaky": {500, 429, 200}, "/broken": {400}, "/down": {503, 503, 503}, "/retry": {503}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		n := len(received[r.URL.Path])
+		received[r.URL.Path] = append(received[r.URL.Path], r)
+		bodies[r.URL.Path] = append(bodies[r.URL.Path], string(body))
+		mu.Unlock()
+		if "/slow" == r.URL.Path {
+			time.Sleep(200 * time.Millisecond)
+		}
+		status := http.StatusNoContent
+		if a := answers[r.URL.Path]; n < len(a) {
+			status = a[n]
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := func(name string, events ...string) conf.Webhook {
+		return conf.Webhook{Name: name, URL: srv.URL + "/" + name, Events: events, ContentType: "application/json",
+			SignatureHeader: "X-Remote-Move-Signature", Timeout: 50 * time.Millisecond, Attempts: 3, Backoff: time.Millisecond}
+	}
+	movies := hook("movies", "move.succeeded")
+	movies.Secret = "s3cret"
+	movies.Body = `{"text":"{{.User}} moved {{len .Items}} to {{.Dest}}","first":{{json (index .Items 0).Item}}}`
+	if _, err := New([]conf.Webhook{{Name: "bad", Body: "{{.Nope"}}); nil == err {
+		t.Fatalf("an invalid template should be refused")
+	}
+	d, err := New([]conf.Webhook{movies, hook("failures", "*.failed"), hook("flaky"), hook("broken"), hook("down"), hook("slow")})
+	if nil != err {
+		t.Fatalf("could not create the dispatcher %v", err)
+	}
+
+	d.Fire(NewEvent("op1", "alice", "move", "/dl", "media/movies", []Item{{Item: `"film"`, Status: 200}}))
+	d.Wait()
+
+	mu.Lock()
+	if 1 != len(received["/movies"]) || 0 != len(received["/failures"]) {
+		t.Fatalf("only the subscribed webhooks should be fired, got %v", received)
+	}
+	r, body := received["/movies"][0], bodies["/movies"][0]
+	if `{"text":"alice moved 1 to media/movies","first":"\"film\""}` != body {
+		t.Fatalf("the body should be the template, got %s", body)
+	}
+	signature := r.Header.Get("X-Remote-Move-Signature")
+	if !Verify("s3cret", signature, []byte(body), time.Now()) || "move.succeeded" != r.Header.Get("X-Remote-Move-Event") {
+		t.Fatalf("the event should be signed, got %v", r.Header)
+	}
+	if Verify("s3cret", signature, []byte(body+" "), time.Now()) || Verify("other", signature, []byte(body), time.Now()) {
+		t.Fatalf("the signature should be of the body and the secret")
+	}
+	if Verify("s3cret", signature, []byte(body), time.Now().Add(SignatureTolerance+time.Minute)) {
+		t.Fatalf("an old signature should be refused as a replay")
+	}
+	if 3 != len(received["/flaky"]) || 1 != len(received["/broken"]) || 3 != len(received["/down"]) {
+		t.Fatalf("server errors should be retried, client errors not, got %d %d %d", len(received["/flaky"]), len(received["/broken"]), len(received["/down"]))
+	}
+	mu.Unlock()
+
+	status := make(map[string]Delivery)
+	for _, dl := range d.Deliveries("") {
+		status[dl.Webhook] = dl
+	}
+	cases := map[string]struct {
+		status   string
+		attempts int
+		answer   int
+	}{
+		"movies": {StatusDelivered, 1, 204},
+		"flaky":  {StatusDelivered, 3, 200},
+		"broken": {StatusFailed, 1, 400},
+		"down":   {StatusFailed, 3, 503},
+		"slow":   {StatusFailed, 3, 0},
+	}
+	for name, c := range cases {
+		dl := status[name]
+		if c.status != dl.Status || c.attempts != dl.Attempts || c.answer != dl.ResponseStatus || "op1" != dl.EventID {
+			t.Fatalf("%s should be %s after %d attempts answered %d, got %+v", name, c.status, c.attempts, c.answer, dl)
+		}
+	}
+
+	d.Fire(NewEvent("op2", "alice", "copy", "/dl", "media/movies", []Item{{Item: "film", Status: 200}, {Item: "gone", Status: 404, Error: "not found"}}))
+	d.Wait()
+	if dl := d.Deliveries("failures"); 1 != len(dl) || "copy.failed" != dl[0].Event || StatusDelivered != dl[0].Status {
+		t.Fatalf("a failed item should fire copy.failed, got %+v", dl)
+	}
+
+	slow, _ := New([]conf.Webhook{{Name: "retry", URL: srv.URL + "/retry", ContentType: "application/json", Timeout: time.Second, Attempts: 2, Backoff: time.Hour}})
+	slow.Fire(NewEvent("op3", "alice", "move", "/dl", "media/movies", nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := slow.Shutdown(ctx); nil != err {
+		t.Fatalf("shutting down should retry without waiting for the backoff, got %v", err)
+	}
+	if dl := slow.Deliveries(""); 1 != len(dl) || 2 != dl[0].Attempts || StatusDelivered != dl[0].Status {
+		t.Fatalf("the retry should be made before shutting down, got %+v", dl)
+	}
+
+	var none *Dispatcher
+	none.Fire(NewEvent("op4", "", "move", "/dl", "media", nil))
+	if 0 != len(none.Deliveries("")) {
+		t.Fatalf("without webhooks there are no deliveries")
+	}
+}